@@ -0,0 +1,253 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// the fraction of the darkest and brightest channel values ignored by auto-levels, so that a few
+// stray pixels do not prevent the rest of the image from being stretched
+const autoLevelsClip = 0.001
+
+type AdjustCfg struct {
+	IsUsed     bool
+	Brightness float64 // -1 to 1; added to every channel
+	Contrast   float64 // -1 to 1; -1 is flat gray, and 1 doubles the distance of every channel from mid gray
+	Gamma      float64 // > 0; values > 1 brighten the midtones, and values < 1 darken them
+	Saturation float64 // -1 to 1; -1 is grayscale, and 1 doubles the saturation
+	Hue        float64 // degrees to rotate the hue of every color
+	AutoLevels bool    // stretch the range of channel values to use the full range, before the other adjustments
+	Threads    int
+}
+
+func NewAdjustCfg(opts ...AdjustOpt) AdjustCfg {
+	cfg := AdjustCfg{
+		IsUsed: false,
+		Gamma:  1,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type AdjustOpt func(*AdjustCfg)
+
+func clampPercent(p float64) float64 {
+	return min(max(p, -100), 100) / 100
+}
+
+// percent is clamped to -100 - 100
+func WithBrightness(percent float64) func(*AdjustCfg) {
+	if percent == 0 {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.Brightness = clampPercent(percent)
+	}
+}
+
+// percent is clamped to -100 - 100
+func WithContrast(percent float64) func(*AdjustCfg) {
+	if percent == 0 {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.Contrast = clampPercent(percent)
+	}
+}
+
+// values <= 0 are ignored
+func WithGamma(gamma float64) func(*AdjustCfg) {
+	if gamma <= 0 || gamma == 1 {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.Gamma = gamma
+	}
+}
+
+// percent is clamped to -100 - 100
+func WithSaturation(percent float64) func(*AdjustCfg) {
+	if percent == 0 {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.Saturation = clampPercent(percent)
+	}
+}
+
+func WithHue(degrees float64) func(*AdjustCfg) {
+	degrees = math.Mod(degrees, 360)
+	if degrees == 0 {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.Hue = degrees
+	}
+}
+
+func WithAutoLevels(autoLevels bool) func(*AdjustCfg) {
+	if !autoLevels {
+		return func(*AdjustCfg) {}
+	}
+	return func(a *AdjustCfg) {
+		a.IsUsed = true
+		a.AutoLevels = true
+	}
+}
+
+// the number of goroutines used to adjust a single image; values < 1 use all CPUs
+func WithAdjustThreads(n int) func(*AdjustCfg) {
+	return func(a *AdjustCfg) {
+		a.Threads = n
+	}
+}
+
+// nrgba64At returns a function that reads the non-premultiplied color of the pixels of img
+func nrgba64At(img image.Image) func(x, y int) color.NRGBA64 {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return func(x, y int) color.NRGBA64 {
+			c := m.NRGBAAt(x, y)
+			return color.NRGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+		}
+	case *image.NRGBA64:
+		return m.NRGBA64At
+	}
+	return func(x, y int) color.NRGBA64 {
+		return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+	}
+}
+
+// levels returns the range of channel values of the non-transparent pixels of img, ignoring the
+// darkest and brightest autoLevelsClip of them
+func levels(img image.Image) (lo, hi float64) {
+	var hist [256]int
+	at := nrgba64At(img)
+	b := img.Bounds()
+	total := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := at(x, y)
+			if c.A == 0 {
+				continue
+			}
+			hist[c.R>>8]++
+			hist[c.G>>8]++
+			hist[c.B>>8]++
+			total += 3
+		}
+	}
+	if total == 0 {
+		return 0, 1
+	}
+	clip := int(float64(total) * autoLevelsClip)
+	l, h := 0, 255
+	for n := hist[l]; n <= clip && l < 255; n += hist[l] {
+		l++
+	}
+	for n := hist[h]; n <= clip && h > 0; n += hist[h] {
+		h--
+	}
+	if l >= h {
+		return 0, 1
+	}
+	return float64(l) / 255, float64(h) / 255
+}
+
+// Adjust returns a copy of img with the adjustments in cfg applied to its colors, with its origin at (0, 0).
+// Brightness, contrast, gamma, and auto-levels are applied to each channel separately; saturation and hue
+// are applied to the resulting colors.
+func Adjust(img image.Image, cfg AdjustCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	lo, hi := 0.0, 1.0
+	if cfg.AutoLevels {
+		lo, hi = levels(img)
+	}
+	gamma := cfg.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	lut := make([]uint16, 0x10000)
+	for v := range lut {
+		f := (float64(v)/0xffff - lo) / (hi - lo)
+		f += cfg.Brightness
+		f = (f-0.5)*(1+cfg.Contrast) + 0.5
+		f = min(max(f, 0), 1)
+		if gamma != 1 {
+			f = math.Pow(f, 1/gamma)
+		}
+		lut[v] = uint16(math.Round(f * 0xffff))
+	}
+
+	// saturation mixes each color with its luma; hue rotates colors around the gray axis, as in the CSS
+	// hue-rotate() filter
+	var mat [3][3]float64
+	useMat := cfg.Saturation != 0 || cfg.Hue != 0
+	if useMat {
+		lum := [3]float64{0.2126, 0.7152, 0.0722}
+		s := 1 + cfg.Saturation
+		var sat [3][3]float64
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				sat[i][j] = (1 - s) * lum[j]
+				if i == j {
+					sat[i][j] += s
+				}
+			}
+		}
+		sin, cos := math.Sincos(cfg.Hue * math.Pi / 180)
+		hue := [3][3]float64{
+			{0.213 + cos*0.787 - sin*0.213, 0.715 - cos*0.715 - sin*0.715, 0.072 - cos*0.072 + sin*0.928},
+			{0.213 - cos*0.213 + sin*0.143, 0.715 + cos*0.285 + sin*0.140, 0.072 - cos*0.072 - sin*0.283},
+			{0.213 - cos*0.213 - sin*0.787, 0.715 - cos*0.715 + sin*0.715, 0.072 + cos*0.928 + sin*0.072},
+		}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				for k := 0; k < 3; k++ {
+					mat[i][j] += hue[i][k] * sat[k][j]
+				}
+			}
+		}
+	}
+
+	b := img.Bounds()
+	dst := NewDstImage(img, b.Sub(b.Min), true)
+	at := nrgba64At(img)
+	forBands(dst.Bounds(), cfg.Threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				c := at(b.Min.X+x, b.Min.Y+y)
+				c.R, c.G, c.B = lut[c.R], lut[c.G], lut[c.B]
+				if useMat {
+					r, g, bl := float64(c.R), float64(c.G), float64(c.B)
+					c.R = clampChannel(mat[0][0]*r + mat[0][1]*g + mat[0][2]*bl)
+					c.G = clampChannel(mat[1][0]*r + mat[1][1]*g + mat[1][2]*bl)
+					c.B = clampChannel(mat[2][0]*r + mat[2][1]*g + mat[2][2]*bl)
+				}
+				switch d := dst.(type) {
+				case *image.NRGBA:
+					d.SetNRGBA(x, y, color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+				case *image.NRGBA64:
+					d.SetNRGBA64(x, y, c)
+				default:
+					d.Set(x, y, c)
+				}
+			}
+		}
+	})
+	return dst
+}
+
+func clampChannel(f float64) uint16 {
+	return uint16(min(max(math.Round(f), 0), 0xffff))
+}
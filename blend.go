@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// BlendMode determines how the colors of an image drawn by Composite combine with the colors under it.
+type BlendMode int
+
+const (
+	BlendNormal     BlendMode = iota // the top color covers the bottom one
+	BlendMultiply                    // darkens; white is neutral
+	BlendScreen                      // lightens; black is neutral
+	BlendOverlay                     // multiplies dark backdrop colors and screens light ones, increasing contrast
+	BlendDarken                      // keeps the darker of the two colors, per channel
+	BlendLighten                     // keeps the lighter of the two colors, per channel
+	BlendDifference                  // the absolute difference of the two colors; black is neutral
+	BlendSoftLight                   // a gentler overlay, as in the W3C compositing specification
+)
+
+var blendNames = []string{"normal", "multiply", "screen", "overlay", "darken", "lighten", "difference", "softlight"}
+
+func (m BlendMode) String() string {
+	if int(m) < len(blendNames) {
+		return blendNames[m]
+	}
+	return fmt.Sprintf("BlendMode(%d)", int(m))
+}
+
+// ParseBlendMode accepts the names of the blend modes, e.g. multiply; names are not case sensitive.
+func ParseBlendMode(s string) (BlendMode, error) {
+	for i, name := range blendNames {
+		if strings.EqualFold(s, name) {
+			return BlendMode(i), nil
+		}
+	}
+	return BlendNormal, fmt.Errorf("unknown blend mode %q; options are %s", s, strings.Join(blendNames, ", "))
+}
+
+// blend combines a non-premultiplied backdrop channel cb with a source channel cs
+func (m BlendMode) blend(cb, cs float32) float32 {
+	switch m {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return BlendScreen.blend(cs, 2*cb-1)
+	case BlendDarken:
+		return min(cb, cs)
+	case BlendLighten:
+		return max(cb, cs)
+	case BlendDifference:
+		if cb > cs {
+			return cb - cs
+		}
+		return cs - cb
+	case BlendSoftLight:
+		if cs <= 0.5 {
+			return cb - (1-2*cs)*cb*(1-cb)
+		}
+		var d float32
+		if cb <= 0.25 {
+			d = ((16*cb-12)*cb + 4) * cb
+		} else {
+			d = float32(math.Sqrt(float64(cb)))
+		}
+		return cb + (2*cs-1)*(d-cb)
+	}
+	return cs
+}
+
+// compositePixel composites the premultiplied color s over the premultiplied color d, in place, as in the
+// W3C compositing specification: where both are opaque, the result is the blend of their colors.
+func (m BlendMode) compositePixel(d, s []float32) {
+	as, ab := s[3], d[3]
+	if as == 0 {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		var mixed float32
+		if ab > 0 {
+			mixed = as * ab * m.blend(d[i]/ab, s[i]/as)
+		}
+		d[i] = (1-ab)*s[i] + (1-as)*d[i] + mixed
+	}
+	d[3] = as + ab*(1-as)
+}
+
+// Composite draws src onto the r region of dst, with sp aligned to r.Min as in draw.Draw, combining the
+// colors with mode; opacity (0-1) scales the alpha of src. Rows are composited on up to threads goroutines.
+func Composite(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, mode BlendMode, opacity float64, threads int) {
+	CompositeMask(dst, r, src, sp, nil, image.Point{}, mode, opacity, threads)
+}
+
+// CompositeMask is like Composite, but the alpha of src is also scaled by the luma of mask, with mp aligned to r.Min;
+// black or transparent parts of mask hide src. Parts of r outside of mask are hidden. A nil mask hides nothing.
+func CompositeMask(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, mask image.Image, mp image.Point, mode BlendMode, opacity float64, threads int) {
+	clipped := r.Intersect(dst.Bounds())
+	sp = sp.Add(clipped.Min.Sub(r.Min))
+	mp = mp.Add(clipped.Min.Sub(r.Min))
+	r = clipped
+	// clip r to the part covered by src (and mask)
+	sr := src.Bounds().Intersect(image.Rectangle{sp, sp.Add(r.Size())})
+	r = image.Rectangle{r.Min.Add(sr.Min.Sub(sp)), r.Min.Add(sr.Max.Sub(sp))}
+	mp = mp.Add(sr.Min.Sub(sp))
+	if mask != nil {
+		mr := mask.Bounds().Intersect(image.Rectangle{mp, mp.Add(r.Size())})
+		r = image.Rectangle{r.Min.Add(mr.Min.Sub(mp)), r.Min.Add(mr.Max.Sub(mp))}
+		sr.Min = sr.Min.Add(mr.Min.Sub(mp))
+		mp = mr.Min
+	}
+	if r.Empty() || opacity <= 0 {
+		return
+	}
+	op := float32(min(opacity, 1))
+	forBands(r, threads, func(band image.Rectangle) {
+		drow := make([]float32, r.Dx()*4)
+		srow := make([]float32, r.Dx()*4)
+		var mrow []float32
+		if mask != nil {
+			mrow = make([]float32, r.Dx()*4)
+		}
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			readPremulRow(drow, dst, r.Min.X, r.Max.X, y)
+			readPremulRow(srow, src, sr.Min.X, sr.Min.X+r.Dx(), sr.Min.Y+y-r.Min.Y)
+			if mask != nil {
+				readPremulRow(mrow, mask, mp.X, mp.X+r.Dx(), mp.Y+y-r.Min.Y)
+			}
+			for i := 0; i < len(drow); i += 4 {
+				s := srow[i : i+4]
+				a := op
+				if mask != nil {
+					a *= 0.2126*mrow[i] + 0.7152*mrow[i+1] + 0.0722*mrow[i+2]
+				}
+				if a < 1 {
+					s[0], s[1], s[2], s[3] = s[0]*a, s[1]*a, s[2]*a, s[3]*a
+				}
+				mode.compositePixel(drow[i:i+4], s)
+				writePremul(dst, r.Min.X+i/4, y, drow[i:i+4], draw.Src)
+			}
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the reference values follow the formulas of the W3C Compositing and Blending Level 1 specification
+func TestBlend(t *testing.T) {
+	pairs := [4][2]float32{{0.2, 0.7}, {0.8, 0.3}, {0.1, 0.9}, {0.6, 0.6}} // backdrop, source
+	for _, tc := range []struct {
+		mode BlendMode
+		want [4]float32
+	}{
+		{BlendNormal, [4]float32{0.7, 0.3, 0.9, 0.6}},
+		{BlendMultiply, [4]float32{0.14, 0.24, 0.09, 0.36}},
+		{BlendScreen, [4]float32{0.76, 0.86, 0.91, 0.84}},
+		{BlendOverlay, [4]float32{0.28, 0.72, 0.18, 0.68}},
+		{BlendDarken, [4]float32{0.2, 0.3, 0.1, 0.6}},
+		{BlendLighten, [4]float32{0.7, 0.8, 0.9, 0.6}},
+		{BlendDifference, [4]float32{0.5, 0.5, 0.8, 0}},
+		{BlendSoftLight, [4]float32{0.2992, 0.736, 0.2568, 0.634919}},
+	} {
+		for i, p := range pairs {
+			if got := tc.mode.blend(p[0], p[1]); math.Abs(float64(got-tc.want[i])) > 1e-5 {
+				t.Errorf("%s(%v, %v) = %v, want %v", tc.mode, p[0], p[1], got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestCompositePixel(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mode BlendMode
+		d, s [4]float32 // premultiplied
+		want [4]float32
+	}{
+		{"opaque", BlendMultiply, [4]float32{0.5, 1, 0.2, 1}, [4]float32{0.5, 0.5, 1, 1}, [4]float32{0.25, 0.5, 0.2, 1}},
+		{"half transparent source", BlendNormal, [4]float32{1, 1, 1, 1}, [4]float32{0.5, 0, 0, 0.5}, [4]float32{1, 0.5, 0.5, 1}},
+		{"transparent backdrop", BlendMultiply, [4]float32{}, [4]float32{0.3, 0.2, 0.1, 0.5}, [4]float32{0.3, 0.2, 0.1, 0.5}},
+		{"transparent source", BlendScreen, [4]float32{0.1, 0.2, 0.3, 0.4}, [4]float32{}, [4]float32{0.1, 0.2, 0.3, 0.4}},
+		// both half transparent: (1-ab)*s + (1-as)*d + as*ab*B(d/ab, s/as)
+		{"half transparent", BlendScreen, [4]float32{0.25, 0, 0, 0.5}, [4]float32{0, 0.25, 0, 0.5}, [4]float32{0.25, 0.25, 0, 0.75}},
+	} {
+		d := tc.d
+		tc.mode.compositePixel(d[:], tc.s[:])
+		for i := range d {
+			if math.Abs(float64(d[i]-tc.want[i])) > 1e-6 {
+				t.Errorf("%s: got %v, want %v", tc.name, d, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Codec describes an image format. Formats are added to the registry with RegisterCodec,
+// so adding a format only requires registering it; see codecs.go for the built-in formats.
+type Codec struct {
+	Name         string   // canonical name, used in messages
+	Aliases      []string // other names accepted by the -to flag
+	MIMETypes    []string
+	Extensions   []string // without the leading period; the first one is used for output files
+	Magic        []string // file signatures; '?' matches any byte, as with image.RegisterFormat
+	Decode       func(io.Reader) (image.Image, error)
+	DecodeConfig func(io.Reader) (image.Config, error)
+	Encode       func(io.Writer, image.Image, EncodeCfg) error // nil if the format cannot be written
+	Disabled     string                                        // if not empty, explains why Encode is unavailable in this build
+	HighBitDepth bool                                          // Encode can write 16 bits per channel
+	Alpha        bool                                          // Encode can write transparency; if not, images are flattened first
+	Options      []CodecOption                                 // the encoder's option schema
+}
+
+// CodecOption describes a command line flag that configures a Codec's encoder.
+type CodecOption struct {
+	Flag   string
+	Usage  string
+	IsBool bool
+	// Value returns the setting of the option in an EncodeCfg as a flag value; the default of the flag is its
+	// setting in NewEncodeCfg, so the two cannot disagree
+	Value func(EncodeCfg) string
+	// Parse validates a flag value and converts it to an EncodeOpt
+	Parse func(string) (EncodeOpt, error)
+}
+
+var codecs []*Codec
+
+func RegisterCodec(c *Codec) {
+	codecs = append(codecs, c)
+}
+
+// Codecs returns all registered codecs in registration order.
+func Codecs() []*Codec {
+	return codecs
+}
+
+// Ext returns the file extension, without the leading period, used for output files.
+func (c *Codec) Ext() string {
+	if len(c.Extensions) < 1 {
+		return c.Name
+	}
+	return c.Extensions[0]
+}
+
+// EncoderNames returns the names of the registered codecs that can be written by this build, as a list
+// for messages, e.g. "gif, jpeg, png, and tiff".
+func EncoderNames() string {
+	var names []string
+	for _, c := range codecs {
+		if c.CanEncode() {
+			names = append(names, c.Name)
+		}
+	}
+	if len(names) > 1 {
+		names[len(names)-1] = "and " + names[len(names)-1]
+	}
+	if len(names) == 2 {
+		return strings.Join(names, " ")
+	}
+	return strings.Join(names, ", ")
+}
+
+// CanEncode reports whether the format can be written by this build.
+func (c *Codec) CanEncode() bool {
+	return c.Encode != nil && c.Disabled == ""
+}
+
+// RETURNS nil IF s DOES NOT MATCH ANY REGISTERED CODEC
+// s may be a name, an alias, a MIME type, or a file extension (with or without the leading period)
+func LookupCodec(s string) *Codec {
+	s = strings.TrimPrefix(strings.ToLower(s), ".")
+	for _, c := range codecs {
+		if c.Name == s || contains(c.Aliases, s) || contains(c.MIMETypes, s) || contains(c.Extensions, s) {
+			return c
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchMagic(magic string, b []byte) bool {
+	if len(magic) != len(b) {
+		return false
+	}
+	for i, c := range b {
+		if magic[i] != c && magic[i] != '?' {
+			return false
+		}
+	}
+	return true
+}
+
+type peeker interface {
+	io.Reader
+	Peek(int) ([]byte, error)
+}
+
+// RETURNS nil IF THE HEADER DOES NOT MATCH ANY REGISTERED CODEC
+func sniff(r peeker) *Codec {
+	for _, c := range codecs {
+		for _, magic := range c.Magic {
+			b, err := r.Peek(len(magic))
+			if err == nil && matchMagic(magic, b) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeImage decodes an image in any registered format. Like image.Decode, it identifies
+// the format by its magic bytes rather than trusting file names or headers.
+func DecodeImage(r io.Reader) (image.Image, *Codec, error) {
+	pr, ok := r.(peeker)
+	if !ok {
+		pr = bufio.NewReader(r)
+	}
+	c := sniff(pr)
+	if c == nil {
+		return nil, nil, image.ErrFormat
+	}
+	if c.Decode == nil {
+		return nil, c, fmt.Errorf("%s decoding is not supported", c.Name)
+	}
+	img, err := c.Decode(pr)
+	return img, c, err
+}
+
+// codecFlag is a flag.Value backed by a CodecOption
+type codecFlag struct {
+	codec *Codec
+	opt   CodecOption
+	value string
+	set   bool
+}
+
+func (f *codecFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *codecFlag) Set(s string) error {
+	if _, err := f.opt.Parse(s); err != nil {
+		return err
+	}
+	f.value = s
+	f.set = true
+	return nil
+}
+
+func (f *codecFlag) IsBoolFlag() bool {
+	return f.opt.IsBool
+}
+
+type CodecFlags []*codecFlag
+
+// DefineCodecFlags adds a flag to fs for every option of every registered codec.
+func DefineCodecFlags(fs *flag.FlagSet) CodecFlags {
+	var cf CodecFlags
+	defaults := NewEncodeCfg(nil)
+	for _, c := range codecs {
+		for _, opt := range c.Options {
+			f := &codecFlag{codec: c, opt: opt, value: opt.Value(defaults)}
+			fs.Var(f, opt.Flag, opt.Usage)
+			cf = append(cf, f)
+		}
+	}
+	return cf
+}
+
+// EncodeOpts returns the options for the flags that were set and apply to c,
+// along with the names of the flags that were set but do not apply to c.
+func (cf CodecFlags) EncodeOpts(c *Codec) ([]EncodeOpt, []string) {
+	var opts []EncodeOpt
+	var ignored []string
+	for _, f := range cf {
+		if !f.set {
+			continue
+		}
+		if f.codec != c {
+			ignored = append(ignored, f.opt.Flag)
+			continue
+		}
+		opt, _ := f.opt.Parse(f.value) // already validated by Set
+		opts = append(opts, opt)
+	}
+	sort.Strings(ignored)
+	return opts, ignored
+}
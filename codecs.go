@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+
+	"github.com/cdillond/imgconv/pkg/avifenc"
+	"github.com/cdillond/imgconv/pkg/jxl"
+	"github.com/cdillond/imgconv/pkg/webpenc"
+)
+
+// the built-in formats
+func init() {
+	RegisterCodec(&Codec{
+		Name:         "gif",
+		MIMETypes:    []string{"image/gif"},
+		Extensions:   []string{"gif"},
+		Magic:        []string{"GIF87a", "GIF89a"},
+		Decode:       gif.Decode,
+		DecodeConfig: gif.DecodeConfig,
+		Alpha:        true, // fully transparent pixels only
+		Encode:       encodeGif,
+		Options: []CodecOption{
+			{Flag: "gifNumColors", Value: intValue(func(e EncodeCfg) int { return e.GifNumColors }), Usage: "the maximum number of colors in output gif files; accepted values are 1-256", Parse: intOption(WithGifNumColors)},
+		},
+	})
+
+	RegisterCodec(&Codec{
+		Name:         "jpeg",
+		Aliases:      []string{"jpg"},
+		MIMETypes:    []string{"image/jpeg"},
+		Extensions:   []string{"jpeg", "jpg", "jpe", "jfif"},
+		Magic:        []string{"\xff\xd8"},
+		Decode:       jpeg.Decode,
+		DecodeConfig: jpeg.DecodeConfig,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: cfg.JpegQuality})
+		},
+		Options: []CodecOption{
+			{Flag: "jpegQual", Value: intValue(func(e EncodeCfg) int { return e.JpegQuality }), Usage: "the image quality of output jpeg files; accepted values are 0-100 (low - high)", Parse: intOption(WithJpegQuality)},
+			{Flag: "jpegLossless", Value: boolValue(func(e EncodeCfg) bool { return e.JpegLossless }), IsBool: true, Usage: "if true, local jpeg files that are only rotated, flipped, or cropped are transformed without being re-encoded when converting to jpeg, if the crop and mirrored edges are aligned to the 8 or 16 pixel blocks of the file", Parse: boolOption(WithJpegLossless)},
+		},
+	})
+
+	RegisterCodec(&Codec{
+		Name:         "png",
+		MIMETypes:    []string{"image/png"},
+		Extensions:   []string{"png"},
+		Magic:        []string{"\x89PNG\r\n\x1a\n"},
+		Decode:       png.Decode,
+		DecodeConfig: png.DecodeConfig,
+		HighBitDepth: true,
+		Alpha:        true,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return png.Encode(w, img)
+		},
+	})
+
+	RegisterCodec(&Codec{
+		Name:         "tiff",
+		Aliases:      []string{"tif"},
+		MIMETypes:    []string{"image/tiff"},
+		Extensions:   []string{"tiff", "tif"},
+		Magic:        []string{"II*\x00", "MM\x00*"},
+		Decode:       tiff.Decode,
+		DecodeConfig: tiff.DecodeConfig,
+		HighBitDepth: true,
+		Alpha:        true,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return tiff.Encode(w, img, &tiff.Options{
+				Compression: cfg.TiffCompType,
+				Predictor:   cfg.TiffPredictor})
+		},
+	})
+
+	webpCodec := &Codec{
+		Name:         "webp",
+		MIMETypes:    []string{"image/webp"},
+		Extensions:   []string{"webp"},
+		Magic:        []string{"RIFF????WEBPVP8"},
+		Decode:       webp.Decode,
+		DecodeConfig: webp.DecodeConfig,
+		Alpha:        true,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return webpenc.EncodeWebP(w, img, webpenc.WebPOptions{IsLossy: cfg.WebPLossy, Quality: cfg.WebPQuality})
+		},
+		Options: []CodecOption{
+			{Flag: "webpLossy", Value: boolValue(func(e EncodeCfg) bool { return e.WebPLossy }), IsBool: true, Usage: "if true, lossy compression will be used for webp encoding", Parse: boolOption(WithWebPLossy)},
+			{Flag: "webpQual", Value: uintValue(func(e EncodeCfg) uint { return e.WebPQuality }), Usage: "the image quality of output webp files when -webpLossy=true; accepted values are 0-100 (low - high)", Parse: uintOption(WithWebPQual)},
+		},
+	}
+	if !webpenc.ENCODE_ENABLED { // defined in webp.go and webp_cgo.go
+		webpCodec.Disabled = "webp encoding is not enabled; review the documentation at github.com/cdillond/imgconv for details"
+	}
+	RegisterCodec(webpCodec)
+
+	// there is no avif decoder, but the format is still registered so that it can be identified
+	avifCodec := &Codec{
+		Name:       "avif",
+		MIMETypes:  []string{"image/avif"},
+		Extensions: []string{"avif"},
+		Magic:      []string{"????ftypavif", "????ftypavis"},
+		Alpha:      true,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return avifenc.EncodeAVIF(w, img, avifenc.AVIFOptions{
+				Quality:      cfg.AvifQuality,
+				AlphaQuality: cfg.AvifAlphaQuality,
+				Speed:        cfg.AvifSpeed,
+				Subsample:    cfg.AvifSubsample,
+				Codec:        cfg.AvifCodec})
+		},
+		Options: []CodecOption{
+			{Flag: "avifQual", Value: uintValue(func(e EncodeCfg) uint { return e.AvifQuality }), Usage: "the image quality of output avif files; accepted values are 0-100 (low - high)", Parse: uintOption(WithAvifQual)},
+			{Flag: "avifAlphaQual", Value: uintValue(func(e EncodeCfg) uint { return e.AvifAlphaQuality }), Usage: "the quality of the alpha channel of output avif files; accepted values are 0-100 (low - high)", Parse: uintOption(WithAvifAlphaQual)},
+			{Flag: "avifSpeed", Value: intValue(func(e EncodeCfg) int { return e.AvifSpeed }), Usage: "the avif encoder speed; accepted values are 0-10 (slow/small - fast/large), or -1 for the codec default", Parse: intOption(WithAvifSpeed)},
+			{Flag: "avifSubsample", Value: stringValue(func(e EncodeCfg) string { return e.AvifSubsample }), Usage: "the chroma subsampling of output avif files; options are 444, 422, 420, and 400 (grayscale)", Parse: choiceOption(WithAvifSubsample, "444", "422", "420", "400")},
+			{Flag: "avifCodec", Value: stringValue(func(e EncodeCfg) string { return e.AvifCodec }), Usage: "the AV1 codec used for avif encoding; options are aom, rav1e, and svt; if not specified, libavif will choose one", Parse: choiceOption(WithAvifCodec, "aom", "rav1e", "svt", "")},
+		},
+	}
+	if !avifenc.ENCODE_ENABLED { // defined in avif.go and avif_cgo.go
+		avifCodec.Disabled = "avif encoding is not enabled; review the documentation at github.com/cdillond/imgconv for details"
+	}
+	RegisterCodec(avifCodec)
+
+	jxlCodec := &Codec{
+		Name:         "jxl",
+		MIMETypes:    []string{"image/jxl"},
+		Extensions:   []string{"jxl"},
+		Magic:        []string{"\xff\x0a", "\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a"},
+		Decode:       jxl.Decode,
+		DecodeConfig: jxl.DecodeConfig,
+		Alpha:        true,
+		Encode: func(w io.Writer, img image.Image, cfg EncodeCfg) error {
+			return jxl.EncodeJXL(w, img, jxl.JXLOptions{
+				Distance: float32(cfg.JxlDistance),
+				Effort:   cfg.JxlEffort,
+				Lossless: cfg.JxlLossless})
+		},
+		Options: []CodecOption{
+			{Flag: "jxlDistance", Value: floatValue(func(e EncodeCfg) float64 { return e.JxlDistance }), Usage: "the butteraugli distance of output jxl files; accepted values are 0-15 (high - low quality), where 0 is mathematically lossless and 1 is visually lossless", Parse: floatOption(WithJxlDistance)},
+			{Flag: "jxlEffort", Value: intValue(func(e EncodeCfg) int { return e.JxlEffort }), Usage: "the jxl encoder effort; accepted values are 1-9 (fast/large - slow/small)", Parse: intOption(WithJxlEffort)},
+			{Flag: "jxlLossless", Value: boolValue(func(e EncodeCfg) bool { return e.JxlLossless }), IsBool: true, Usage: "if true, output jxl files will be encoded losslessly and -jxlDistance is ignored", Parse: boolOption(WithJxlLossless)},
+			{Flag: "jxlTranscodeJpeg", Value: boolValue(func(e EncodeCfg) bool { return e.JxlTranscodeJpeg }), IsBool: true, Usage: "if true, local jpeg files that are not resized are losslessly recompressed when converting to jxl", Parse: boolOption(WithJxlTranscodeJpeg)},
+		},
+	}
+	if !jxl.ENCODE_ENABLED { // defined in jxl.go and jxl_cgo.go
+		jxlCodec.Disabled = "jxl encoding is not enabled; review the documentation at github.com/cdillond/imgconv for details"
+	}
+	RegisterCodec(jxlCodec)
+}
+
+// encodeGif writes img as a gif file. The palettes chosen by gif.Encode have no transparent entry, so the
+// palette of transparent images is built here: one entry is reserved for transparency, and pixels that are
+// less than half opaque use it while the others are made opaque.
+func encodeGif(w io.Writer, img image.Image, cfg EncodeCfg) error {
+	opts := &gif.Options{
+		NumColors: cfg.GifNumColors,
+		Quantizer: cfg.GifQuantizer,
+		Drawer:    cfg.GifDrawer}
+	if isOpaque(img) || gifPalette(img) {
+		return gif.Encode(w, img, opts)
+	}
+	b := img.Bounds()
+	m := image.NewNRGBA(b.Sub(b.Min))
+	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
+	for i := 3; i < len(m.Pix); i += 4 {
+		if m.Pix[i] < 0x80 {
+			m.Pix[i-3], m.Pix[i-2], m.Pix[i-1], m.Pix[i] = 0, 0, 0, 0
+		} else {
+			m.Pix[i] = 0xff
+		}
+	}
+	// with a single color, the palette only holds the transparent entry
+	n := max(opts.NumColors-1, 0)
+	var pal color.Palette
+	if opts.Quantizer != nil {
+		pal = opts.Quantizer.Quantize(make(color.Palette, 0, n), m)
+	} else {
+		pal = append(pal, palette.Plan9[:n]...)
+	}
+	pm := image.NewPaletted(m.Bounds(), append(pal, color.Transparent))
+	drawer := opts.Drawer
+	if drawer == nil {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(pm, pm.Bounds(), m, image.Point{})
+	return gif.Encode(w, pm, &gif.Options{NumColors: len(pm.Palette)})
+}
+
+// gifPalette reports whether img is paletted with only fully opaque and fully transparent colors
+func gifPalette(img image.Image) bool {
+	pm, ok := img.(*image.Paletted)
+	if !ok {
+		return false
+	}
+	for _, c := range pm.Palette {
+		if _, _, _, a := c.RGBA(); a != 0 && a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func intValue(get func(EncodeCfg) int) func(EncodeCfg) string {
+	return func(e EncodeCfg) string { return strconv.Itoa(get(e)) }
+}
+
+func uintValue(get func(EncodeCfg) uint) func(EncodeCfg) string {
+	return func(e EncodeCfg) string { return strconv.FormatUint(uint64(get(e)), 10) }
+}
+
+func floatValue(get func(EncodeCfg) float64) func(EncodeCfg) string {
+	return func(e EncodeCfg) string { return strconv.FormatFloat(get(e), 'g', -1, 64) }
+}
+
+func boolValue(get func(EncodeCfg) bool) func(EncodeCfg) string {
+	return func(e EncodeCfg) string { return strconv.FormatBool(get(e)) }
+}
+
+func stringValue(get func(EncodeCfg) string) func(EncodeCfg) string {
+	return get
+}
+
+func intOption(with func(int) func(*EncodeCfg)) func(string) (EncodeOpt, error) {
+	return func(s string) (EncodeOpt, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		return with(n), nil
+	}
+}
+
+func uintOption(with func(uint) func(*EncodeCfg)) func(string) (EncodeOpt, error) {
+	return func(s string) (EncodeOpt, error) {
+		u, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		return with(uint(u)), nil
+	}
+}
+
+func floatOption(with func(float64) func(*EncodeCfg)) func(string) (EncodeOpt, error) {
+	return func(s string) (EncodeOpt, error) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return with(f), nil
+	}
+}
+
+func boolOption(with func(bool) func(*EncodeCfg)) func(string) (EncodeOpt, error) {
+	return func(s string) (EncodeOpt, error) {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		return with(b), nil
+	}
+}
+
+func choiceOption(with func(string) func(*EncodeCfg), choices ...string) func(string) (EncodeOpt, error) {
+	return func(s string) (EncodeOpt, error) {
+		if !contains(choices, s) {
+			return nil, fmt.Errorf("%q is not one of %q", s, choices)
+		}
+		return with(s), nil
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// ParseColor accepts hex colors (#rgb, #rgba, #rrggbb, or #rrggbbaa; the # is optional),
+// "transparent", and the SVG 1.1 color names (e.g. white or cornflowerblue).
+func ParseColor(s string) (color.NRGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "transparent" || s == "none" {
+		return color.NRGBA{}, nil
+	}
+	if c, ok := colornames.Map[s]; ok {
+		return color.NRGBA{c.R, c.G, c.B, c.A}, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	switch len(hex) {
+	case 3, 4:
+		// expand shorthand, e.g. f80 -> ff8800
+		var b strings.Builder
+		for _, r := range hex {
+			b.WriteRune(r)
+			b.WriteRune(r)
+		}
+		hex = b.String()
+	case 6, 8:
+	default:
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+type ColorMode int
+
+const (
+	ColorNone      ColorMode = iota
+	ColorGray                // luma-weighted grayscale
+	ColorSepia               // warm brown tones
+	ColorDuotone             // the luma of each pixel mapped from ColorCfg.Dark to ColorCfg.Light
+	ColorInvert              // the negative of the image
+	ColorRed                 // the red channel as grayscale
+	ColorGreen               // the green channel as grayscale
+	ColorBlue                // the blue channel as grayscale
+	ColorAlpha               // the alpha channel as grayscale
+	ColorThreshold           // black or white, depending on whether the luma is below ColorCfg.Threshold
+)
+
+var colorModes = map[string]ColorMode{
+	"gray":      ColorGray,
+	"grey":      ColorGray,
+	"sepia":     ColorSepia,
+	"duotone":   ColorDuotone,
+	"invert":    ColorInvert,
+	"red":       ColorRed,
+	"green":     ColorGreen,
+	"blue":      ColorBlue,
+	"alpha":     ColorAlpha,
+	"threshold": ColorThreshold,
+}
+
+func ParseColorMode(s string) (ColorMode, error) {
+	m, ok := colorModes[strings.ToLower(s)]
+	if !ok {
+		return ColorNone, fmt.Errorf("invalid color mode %q; options are gray, sepia, duotone, invert, red, green, blue, alpha, and threshold", s)
+	}
+	return m, nil
+}
+
+// ParseDuotone parses two colors, as accepted by ParseColor, separated by a comma, e.g. "navy,#ffe4b5".
+func ParseDuotone(s string) (dark, light color.Color, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid duotone %q; expected two colors separated by a comma, e.g. navy,#ffe4b5", s)
+	}
+	if dark, err = ParseColor(strings.TrimSpace(parts[0])); err != nil {
+		return nil, nil, err
+	}
+	if light, err = ParseColor(strings.TrimSpace(parts[1])); err != nil {
+		return nil, nil, err
+	}
+	return dark, light, nil
+}
+
+type ColorCfg struct {
+	IsUsed    bool
+	Mode      ColorMode
+	Dark      color.Color // ColorDuotone only
+	Light     color.Color // ColorDuotone only
+	Threshold float64     // 0-1; ColorThreshold only
+	Threads   int
+}
+
+func NewColorCfg(opts ...ColorOpt) ColorCfg {
+	cfg := ColorCfg{
+		IsUsed:    false,
+		Mode:      ColorNone,
+		Dark:      color.Black,
+		Light:     color.White,
+		Threshold: 0.5,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type ColorOpt func(*ColorCfg)
+
+func WithColorMode(mode ColorMode) func(*ColorCfg) {
+	if mode == ColorNone {
+		return func(*ColorCfg) {}
+	}
+	return func(c *ColorCfg) {
+		c.IsUsed = true
+		c.Mode = mode
+	}
+}
+
+// the colors that the darkest and lightest pixels are mapped to by ColorDuotone
+func WithDuotone(dark, light color.Color) func(*ColorCfg) {
+	return func(c *ColorCfg) {
+		c.Dark, c.Light = dark, light
+	}
+}
+
+// t is clamped to 0-1
+func WithThreshold(t float64) func(*ColorCfg) {
+	return func(c *ColorCfg) {
+		c.Threshold = min(max(t, 0), 1)
+	}
+}
+
+// the number of goroutines used to convert a single image; values < 1 use all CPUs
+func WithColorThreads(n int) func(*ColorCfg) {
+	return func(c *ColorCfg) {
+		c.Threads = n
+	}
+}
+
+func luma(c color.NRGBA64) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
+
+// ConvertColor returns a copy of img converted to cfg.Mode, with its origin at (0, 0). ColorGray returns an
+// *image.Gray (or *image.Gray16 for 16-bit sources) if img is opaque, and the channel modes always do, so
+// encoders write single-channel files; transparent images keep their alpha channel in the other modes.
+// ColorThreshold returns an *image.Paletted with black and white (and transparent, if img is not opaque) entries.
+func ConvertColor(img image.Image, cfg ColorCfg) image.Image {
+	if !cfg.IsUsed || cfg.Mode == ColorNone {
+		return img
+	}
+	b := img.Bounds()
+	r := b.Sub(b.Min)
+	at := nrgba64At(img)
+	opaque := isOpaque(img)
+
+	switch cfg.Mode {
+	case ColorThreshold:
+		pal := color.Palette{color.Black, color.White}
+		if !opaque {
+			pal = append(pal, color.Transparent)
+		}
+		dst := image.NewPaletted(r, pal)
+		t := cfg.Threshold * 0xffff
+		forBands(r, cfg.Threads, func(band image.Rectangle) {
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					c := at(b.Min.X+x, b.Min.Y+y)
+					switch {
+					case c.A < 0x8000:
+						if !opaque {
+							dst.SetColorIndex(x, y, 2)
+						}
+					case luma(c) >= t:
+						dst.SetColorIndex(x, y, 1)
+					}
+				}
+			}
+		})
+		return dst
+
+	case ColorGray, ColorRed, ColorGreen, ColorBlue, ColorAlpha:
+		channel := func(c color.NRGBA64) uint16 {
+			switch cfg.Mode {
+			case ColorRed:
+				return c.R
+			case ColorGreen:
+				return c.G
+			case ColorBlue:
+				return c.B
+			case ColorAlpha:
+				return c.A
+			}
+			return clampChannel(luma(c))
+		}
+		if cfg.Mode == ColorGray && !opaque {
+			break // handled below, keeping alpha
+		}
+		if Is16Bit(img) {
+			dst := image.NewGray16(r)
+			forBands(r, cfg.Threads, func(band image.Rectangle) {
+				for y := band.Min.Y; y < band.Max.Y; y++ {
+					for x := band.Min.X; x < band.Max.X; x++ {
+						dst.SetGray16(x, y, color.Gray16{channel(at(b.Min.X+x, b.Min.Y+y))})
+					}
+				}
+			})
+			return dst
+		}
+		dst := image.NewGray(r)
+		forBands(r, cfg.Threads, func(band image.Rectangle) {
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				for x := band.Min.X; x < band.Max.X; x++ {
+					dst.SetGray(x, y, color.Gray{uint8(channel(at(b.Min.X+x, b.Min.Y+y)) >> 8)})
+				}
+			}
+		})
+		return dst
+	}
+
+	// the remaining modes map each color to another, keeping alpha
+	var mapColor func(c color.NRGBA64) color.NRGBA64
+	switch cfg.Mode {
+	case ColorGray:
+		mapColor = func(c color.NRGBA64) color.NRGBA64 {
+			l := clampChannel(luma(c))
+			return color.NRGBA64{l, l, l, c.A}
+		}
+	case ColorSepia:
+		mapColor = func(c color.NRGBA64) color.NRGBA64 {
+			r, g, b := float64(c.R), float64(c.G), float64(c.B)
+			return color.NRGBA64{
+				clampChannel(0.393*r + 0.769*g + 0.189*b),
+				clampChannel(0.349*r + 0.686*g + 0.168*b),
+				clampChannel(0.272*r + 0.534*g + 0.131*b),
+				c.A,
+			}
+		}
+	case ColorDuotone:
+		dark := color.NRGBA64Model.Convert(cfg.Dark).(color.NRGBA64)
+		light := color.NRGBA64Model.Convert(cfg.Light).(color.NRGBA64)
+		mix := func(d, l uint16, t float64) uint16 {
+			return clampChannel(float64(d)*(1-t) + float64(l)*t)
+		}
+		mapColor = func(c color.NRGBA64) color.NRGBA64 {
+			t := luma(c) / 0xffff
+			return color.NRGBA64{mix(dark.R, light.R, t), mix(dark.G, light.G, t), mix(dark.B, light.B, t), c.A}
+		}
+	case ColorInvert:
+		mapColor = func(c color.NRGBA64) color.NRGBA64 {
+			return color.NRGBA64{0xffff - c.R, 0xffff - c.G, 0xffff - c.B, c.A}
+		}
+	default:
+		return img
+	}
+	dst := NewDstImage(img, r, true)
+	if _, ok := dst.(*image.Gray16); ok {
+		dst = image.NewNRGBA64(r) // e.g. sepia needs color channels
+	}
+	forBands(r, cfg.Threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := band.Min.X; x < band.Max.X; x++ {
+				c := mapColor(at(b.Min.X+x, b.Min.Y+y))
+				switch d := dst.(type) {
+				case *image.NRGBA:
+					d.SetNRGBA(x, y, color.NRGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+				default:
+					d.Set(x, y, c)
+				}
+			}
+		}
+	})
+	return dst
+}
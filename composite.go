@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Layer is an image drawn over another one by CompositeLayers.
+type Layer struct {
+	Image   image.Image
+	Mask    image.Image // optional; stretched to the size of the layer, its luma scales the alpha of Image
+	Gravity Gravity     // where the layer is placed; Smart is treated as Center
+	Offset  image.Point // moves the layer away from the edges pinned by Gravity, or right and down for Center
+	Scale   float64     // the width of the layer as a fraction of the width of the image; 0 keeps its size
+	Opacity float64     // 0-1
+	Blend   BlendMode
+	Tile    bool // repeat the layer over the whole image, starting from its position
+}
+
+// NewLayer returns a Layer for img that is centered, opaque, and drawn normally.
+func NewLayer(img image.Image) Layer {
+	return Layer{Image: img, Gravity: Center, Opacity: 1, Blend: BlendNormal}
+}
+
+// rect returns the position of a w x h layer in r
+func (l Layer) rect(r image.Rectangle, w, h int) image.Rectangle {
+	p := l.Gravity.Place(r, w, h)
+	dx, dy := l.Offset.X, l.Offset.Y
+	switch l.Gravity {
+	case East, NorthEast, SouthEast:
+		dx = -dx
+	}
+	switch l.Gravity {
+	case South, SouthEast, SouthWest:
+		dy = -dy
+	}
+	return p.Add(image.Pt(dx, dy))
+}
+
+// draw composites l onto dst, which has its origin at (0, 0)
+func (l Layer) draw(dst draw.Image, threads int) {
+	if l.Image == nil || l.Opacity <= 0 {
+		return
+	}
+	r := dst.Bounds()
+	img := l.Image
+	if l.Scale > 0 {
+		ib := img.Bounds()
+		w := max(int(float64(r.Dx())*l.Scale+0.5), 1)
+		h := max(int(float64(w)*float64(ib.Dy())/float64(ib.Dx())+0.5), 1)
+		scaled := image.NewNRGBA64(image.Rect(0, 0, w, h))
+		ParallelScale(draw.CatmullRom, scaled, scaled.Bounds(), img, ib, draw.Src, threads)
+		img = scaled
+	}
+	ib := img.Bounds()
+	if ib.Empty() {
+		return
+	}
+	var mask image.Image
+	if l.Mask != nil {
+		m := image.NewGray16(image.Rect(0, 0, ib.Dx(), ib.Dy()))
+		ParallelScale(draw.ApproxBiLinear, m, m.Bounds(), l.Mask, l.Mask.Bounds(), draw.Src, threads)
+		mask = m
+	}
+
+	pos := l.rect(r, ib.Dx(), ib.Dy())
+	if !l.Tile {
+		CompositeMask(dst, pos, img, ib.Min, mask, image.Point{}, l.Blend, l.Opacity, threads)
+		return
+	}
+	// move the first tile up and left until it covers the top-left corner
+	w, h := ib.Dx(), ib.Dy()
+	x0 := pos.Min.X - (pos.Min.X+w-1)/w*w
+	y0 := pos.Min.Y - (pos.Min.Y+h-1)/h*h
+	for y := y0; y < r.Max.Y; y += h {
+		for x := x0; x < r.Max.X; x += w {
+			CompositeMask(dst, image.Rect(x, y, x+w, y+h), img, ib.Min, mask, image.Point{}, l.Blend, l.Opacity, threads)
+		}
+	}
+}
+
+// ParseLayer parses a layer given as the path of an image in any supported format, followed by optional
+// semicolon-separated settings, e.g. "shadow.png;blend=multiply;opacity=60;gravity=south;offset=0,10".
+// The settings are blend, opacity (percent), gravity, offset (x,y in pixels), scale (percent of the image
+// width), mask (the path of a mask image), and tile (true or false).
+func ParseLayer(spec string) (Layer, error) {
+	parts := strings.Split(spec, ";")
+	img, _, err := DecodeLocal(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return Layer{}, fmt.Errorf("could not read layer %s: %w", parts[0], err)
+	}
+	l := NewLayer(img)
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			return Layer{}, fmt.Errorf("invalid layer setting %q; expected key=value", p)
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		var f float64
+		switch k {
+		case "blend":
+			l.Blend, err = ParseBlendMode(v)
+		case "opacity":
+			f, err = strconv.ParseFloat(v, 64)
+			l.Opacity = min(max(f/100, 0), 1)
+		case "gravity":
+			l.Gravity, err = ParseGravity(v)
+		case "offset":
+			l.Offset, err = ParseOffset(v)
+		case "scale":
+			f, err = strconv.ParseFloat(v, 64)
+			l.Scale = max(f/100, 0)
+		case "mask":
+			l.Mask, _, err = DecodeLocal(v)
+			if err != nil {
+				err = fmt.Errorf("could not read mask %s: %w", v, err)
+			}
+		case "tile":
+			l.Tile, err = strconv.ParseBool(v)
+		default:
+			err = fmt.Errorf("unknown layer setting %q; options are blend, opacity, gravity, offset, scale, mask, and tile", k)
+		}
+		if err != nil {
+			return Layer{}, err
+		}
+	}
+	return l, nil
+}
+
+type CompositeCfg struct {
+	IsUsed  bool
+	Layers  []Layer // drawn in order, from the bottom up
+	Threads int
+}
+
+func NewCompositeCfg(opts ...CompositeOpt) CompositeCfg {
+	cfg := CompositeCfg{
+		IsUsed:  false,
+		Threads: 0,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type CompositeOpt func(*CompositeCfg)
+
+// adds l on top of the layers added before it
+func WithLayer(l Layer) func(*CompositeCfg) {
+	return func(c *CompositeCfg) {
+		c.IsUsed = true
+		c.Layers = append(c.Layers, l)
+	}
+}
+
+// the number of goroutines used to scale and draw each layer; values < 1 use all CPUs
+func WithCompositeThreads(n int) func(*CompositeCfg) {
+	return func(c *CompositeCfg) {
+		c.Threads = n
+	}
+}
+
+// CompositeLayers returns a copy of img with the layers in cfg drawn over it in order, with its origin at (0, 0).
+func CompositeLayers(img image.Image, cfg CompositeCfg) image.Image {
+	if !cfg.IsUsed || len(cfg.Layers) == 0 {
+		return img
+	}
+	b := img.Bounds()
+	r := b.Sub(b.Min)
+	dst := NewDstImage(img, r, true)
+	if _, ok := dst.(*image.Gray16); ok {
+		dst = image.NewNRGBA64(r) // the layers may have color
+	}
+	draw.Draw(dst, r, img, b.Min, draw.Src)
+	for _, l := range cfg.Layers {
+		l.draw(dst, cfg.Threads)
+	}
+	return dst
+}
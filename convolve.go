@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// floatImage holds premultiplied RGBA values in [0, 1], 4 per pixel, with its origin at (0, 0)
+type floatImage struct {
+	w, h int
+	pix  []float32
+}
+
+func newFloatImage(w, h int) *floatImage {
+	return &floatImage{w: w, h: h, pix: make([]float32, w*h*4)}
+}
+
+func toFloatImage(img image.Image, threads int) *floatImage {
+	b := img.Bounds()
+	f := newFloatImage(b.Dx(), b.Dy())
+	forBands(image.Rect(0, 0, f.w, f.h), threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			readPremulRow(f.pix[y*f.w*4:(y+1)*f.w*4], img, b.Min.X, b.Max.X, b.Min.Y+y)
+		}
+	})
+	return f
+}
+
+// toImage converts f to an image that can hold the colors of like (see NewDstImage)
+func (f *floatImage) toImage(like image.Image, threads int) image.Image {
+	dst := NewDstImage(like, image.Rect(0, 0, f.w, f.h), true)
+	forBands(dst.Bounds(), threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := 0; x < f.w; x++ {
+				i := (y*f.w + x) * 4
+				writePremul(dst, x, y, f.pix[i:i+4], draw.Src)
+			}
+		}
+	})
+	return dst
+}
+
+// convolve1D convolves every channel of src with k, whose length must be odd, along rows or columns;
+// pixels beyond the edges repeat the edge pixels
+func convolve1D(src *floatImage, k []float64, horizontal bool, threads int) *floatImage {
+	dst := newFloatImage(src.w, src.h)
+	r := len(k) / 2
+	forBands(image.Rect(0, 0, src.w, src.h), threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := 0; x < src.w; x++ {
+				var sum [4]float64
+				for i, w := range k {
+					sx, sy := x, y
+					if horizontal {
+						sx = min(max(x+i-r, 0), src.w-1)
+					} else {
+						sy = min(max(y+i-r, 0), src.h-1)
+					}
+					p := src.pix[(sy*src.w+sx)*4:]
+					sum[0] += w * float64(p[0])
+					sum[1] += w * float64(p[1])
+					sum[2] += w * float64(p[2])
+					sum[3] += w * float64(p[3])
+				}
+				d := dst.pix[(y*dst.w+x)*4:]
+				d[0], d[1], d[2], d[3] = float32(sum[0]), float32(sum[1]), float32(sum[2]), float32(sum[3])
+			}
+		}
+	})
+	return dst
+}
+
+// convolve2D convolves the color channels of src with k, whose dimensions must be odd; alpha is kept
+func convolve2D(src *floatImage, k [][]float64, threads int) *floatImage {
+	dst := newFloatImage(src.w, src.h)
+	ry, rx := len(k)/2, len(k[0])/2
+	forBands(image.Rect(0, 0, src.w, src.h), threads, func(band image.Rectangle) {
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for x := 0; x < src.w; x++ {
+				var sum [3]float64
+				for j, row := range k {
+					sy := min(max(y+j-ry, 0), src.h-1)
+					for i, w := range row {
+						sx := min(max(x+i-rx, 0), src.w-1)
+						p := src.pix[(sy*src.w+sx)*4:]
+						sum[0] += w * float64(p[0])
+						sum[1] += w * float64(p[1])
+						sum[2] += w * float64(p[2])
+					}
+				}
+				i := (y*dst.w + x) * 4
+				d := dst.pix[i:]
+				d[0], d[1], d[2], d[3] = float32(sum[0]), float32(sum[1]), float32(sum[2]), src.pix[i+3]
+			}
+		}
+	})
+	return dst
+}
+
+func gaussianKernel(sigma float64) []float64 {
+	r := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*r+1)
+	var sum float64
+	for i := range k {
+		x := float64(i - r)
+		k[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+func separable(src *floatImage, k []float64, threads int) *floatImage {
+	return convolve1D(convolve1D(src, k, true, threads), k, false, threads)
+}
+
+type convKind int
+
+const (
+	convGaussian convKind = iota
+	convBox
+	convUnsharp
+	convKernel
+)
+
+// ConvFilter is one of the filters applied by Convolve; use the ConvolveCfg options to create them.
+type ConvFilter struct {
+	kind      convKind
+	sigma     float64 // convGaussian, convUnsharp
+	radius    int     // convBox
+	amount    float64 // convUnsharp
+	threshold float64 // convUnsharp
+	kernel    [][]float64
+}
+
+func (f ConvFilter) apply(src *floatImage, threads int) *floatImage {
+	switch f.kind {
+	case convGaussian:
+		return separable(src, gaussianKernel(f.sigma), threads)
+	case convBox:
+		k := make([]float64, 2*f.radius+1)
+		for i := range k {
+			k[i] = 1 / float64(len(k))
+		}
+		return separable(src, k, threads)
+	case convUnsharp:
+		// add the difference between the image and a blurred copy of it, where it exceeds the threshold
+		blurred := separable(src, gaussianKernel(f.sigma), threads)
+		for i := range src.pix {
+			if i%4 == 3 {
+				continue
+			}
+			diff := src.pix[i] - blurred.pix[i]
+			if math.Abs(float64(diff)) >= f.threshold {
+				blurred.pix[i] = src.pix[i] + float32(f.amount)*diff
+			} else {
+				blurred.pix[i] = src.pix[i]
+			}
+			if i%4 == 2 {
+				blurred.pix[i+1] = src.pix[i+1]
+			}
+		}
+		return blurred
+	case convKernel:
+		return convolve2D(src, f.kernel, threads)
+	}
+	return src
+}
+
+// the built-in 3x3 kernels
+var (
+	SharpenKernel = [][]float64{{0, -1, 0}, {-1, 5, -1}, {0, -1, 0}}
+	EdgeKernel    = [][]float64{{-1, -1, -1}, {-1, 8, -1}, {-1, -1, -1}}
+	EmbossKernel  = [][]float64{{-2, -1, 0}, {-1, 1, 1}, {0, 1, 2}}
+)
+
+type ConvolveCfg struct {
+	IsUsed  bool
+	Filters []ConvFilter // applied in order
+	Threads int
+}
+
+func NewConvolveCfg(opts ...ConvolveOpt) ConvolveCfg {
+	cfg := ConvolveCfg{
+		IsUsed:  false,
+		Threads: 0,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type ConvolveOpt func(*ConvolveCfg)
+
+func addFilter(f ConvFilter) func(*ConvolveCfg) {
+	return func(c *ConvolveCfg) {
+		c.IsUsed = true
+		c.Filters = append(c.Filters, f)
+	}
+}
+
+// blurs the image with a Gaussian kernel; sigma is its standard deviation in pixels, and values <= 0 are ignored
+func WithGaussianBlur(sigma float64) func(*ConvolveCfg) {
+	if sigma <= 0 {
+		return func(*ConvolveCfg) {}
+	}
+	return addFilter(ConvFilter{kind: convGaussian, sigma: sigma})
+}
+
+// blurs the image with a square kernel of 2*radius+1 pixels; values < 1 are ignored
+func WithBoxBlur(radius int) func(*ConvolveCfg) {
+	if radius < 1 {
+		return func(*ConvolveCfg) {}
+	}
+	return addFilter(ConvFilter{kind: convBox, radius: radius})
+}
+
+// sharpens the image by adding amount times the difference between it and a copy blurred with a Gaussian
+// kernel of sigma pixels; differences smaller than threshold (0-1) are left alone, which avoids amplifying noise
+func WithUnsharpMask(sigma, amount, threshold float64) func(*ConvolveCfg) {
+	if sigma <= 0 || amount == 0 {
+		return func(*ConvolveCfg) {}
+	}
+	return addFilter(ConvFilter{kind: convUnsharp, sigma: sigma, amount: amount, threshold: min(max(threshold, 0), 1)})
+}
+
+// convolves the color channels of the image with k, which must have odd dimensions (e.g. SharpenKernel);
+// invalid kernels are ignored, so use ParseKernel to validate them first
+func WithKernel(k [][]float64) func(*ConvolveCfg) {
+	if validKernel(k) != nil {
+		return func(*ConvolveCfg) {}
+	}
+	return addFilter(ConvFilter{kind: convKernel, kernel: k})
+}
+
+// the number of goroutines used to filter a single image; values < 1 use all CPUs
+func WithConvolveThreads(n int) func(*ConvolveCfg) {
+	return func(c *ConvolveCfg) {
+		c.Threads = n
+	}
+}
+
+func validKernel(k [][]float64) error {
+	if len(k)%2 == 0 || len(k[0])%2 == 0 {
+		return fmt.Errorf("kernels must have an odd number of rows and columns")
+	}
+	for _, row := range k {
+		if len(row) != len(k[0]) {
+			return fmt.Errorf("all kernel rows must have the same length")
+		}
+	}
+	return nil
+}
+
+// ParseKernel parses a kernel given as rows separated by semicolons, each with values separated by commas,
+// e.g. "0,-1,0;-1,5,-1;0,-1,0". If the values do not add up to 0, they are divided by their sum, so that the
+// kernel does not change the brightness of the image.
+func ParseKernel(s string) ([][]float64, error) {
+	var k [][]float64
+	var sum float64
+	for _, row := range strings.Split(s, ";") {
+		var r []float64
+		for _, v := range strings.Split(row, ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kernel %q; expected rows of numbers, e.g. 0,-1,0;-1,5,-1;0,-1,0", s)
+			}
+			r = append(r, f)
+			sum += f
+		}
+		k = append(k, r)
+	}
+	if err := validKernel(k); err != nil {
+		return nil, fmt.Errorf("invalid kernel %q; %s", s, err)
+	}
+	if math.Abs(sum) > 1e-9 {
+		for _, r := range k {
+			for i := range r {
+				r[i] /= sum
+			}
+		}
+	}
+	return k, nil
+}
+
+// ParseUnsharp parses "sigma[,amount[,threshold]]", where threshold is in percent; amount defaults to 1 and
+// threshold to 0.
+func ParseUnsharp(s string) (sigma, amount, threshold float64, err error) {
+	parts := strings.Split(s, ",")
+	vals := []float64{0, 1, 0}
+	if len(parts) > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid unsharp mask %q; expected sigma[,amount[,threshold]]", s)
+	}
+	for i, p := range parts {
+		vals[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || vals[i] < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid unsharp mask %q; expected sigma[,amount[,threshold]] with non-negative values", s)
+		}
+	}
+	if vals[0] == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid unsharp mask %q; sigma must be positive", s)
+	}
+	return vals[0], vals[1], vals[2] / 100, nil
+}
+
+// Convolve applies the filters in cfg to img in order and returns the result, with its origin at (0, 0).
+func Convolve(img image.Image, cfg ConvolveCfg) image.Image {
+	if !cfg.IsUsed || len(cfg.Filters) == 0 {
+		return img
+	}
+	f := toFloatImage(img, cfg.Threads)
+	for _, filter := range cfg.Filters {
+		f = filter.apply(f, cfg.Threads)
+	}
+	return f.toImage(img, cfg.Threads)
+}
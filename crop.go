@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type CropCfg struct {
+	IsUsed     bool
+	Rect       [4]float64 // x, y, width, height; ignored if width or height is <= 0
+	Percent    bool       // if true, Rect is given in percent of the image dimensions
+	Aspect     float64    // width / height; ignored if <= 0
+	Gravity    Gravity    // the part of the image kept by an aspect ratio crop; Smart picks the most interesting part
+	AfterScale bool       // crop the scaled image rather than the source image
+}
+
+// ParseCropRect parses "x,y,w,h" in pixels, or "x%,y%,w%,h%" in percent of the image dimensions.
+func ParseCropRect(s string) (rect [4]float64, percent bool, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return rect, false, fmt.Errorf("invalid crop %q; expected x,y,w,h", s)
+	}
+	var n int
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if strings.HasSuffix(p, "%") {
+			n++
+			p = strings.TrimSuffix(p, "%")
+		}
+		rect[i], err = strconv.ParseFloat(p, 64)
+		if err != nil || rect[i] < 0 {
+			return rect, false, fmt.Errorf("invalid crop %q; values must be non-negative numbers", s)
+		}
+	}
+	if n != 0 && n != 4 {
+		return rect, false, fmt.Errorf("invalid crop %q; either all or none of the values must be percentages", s)
+	}
+	if rect[2] <= 0 || rect[3] <= 0 {
+		return rect, false, fmt.Errorf("invalid crop %q; width and height must be positive", s)
+	}
+	return rect, n == 4, nil
+}
+
+// ParseAspect parses an aspect ratio given as "w:h" (e.g. 16:9) or as a single number (e.g. 1.5).
+func ParseAspect(s string) (float64, error) {
+	w, h, found := strings.Cut(s, ":")
+	a, err := strconv.ParseFloat(w, 64)
+	if err == nil && found {
+		var d float64
+		d, err = strconv.ParseFloat(h, 64)
+		if d <= 0 {
+			err = fmt.Errorf("zero height")
+		}
+		a /= d
+	}
+	if err != nil || a <= 0 || math.IsInf(a, 0) || math.IsNaN(a) {
+		return 0, fmt.Errorf("invalid aspect ratio %q; expected w:h or a positive number", s)
+	}
+	return a, nil
+}
+
+// CropRect returns the region of r selected by cfg. The explicit rectangle is applied first,
+// and the aspect ratio crop is applied to what remains. The result is always inside r; an explicit
+// rectangle that does not overlap r is ignored.
+func CropRect(r image.Rectangle, cfg CropCfg) image.Rectangle {
+	if !cfg.IsUsed {
+		return r
+	}
+	if cfg.Rect[2] > 0 && cfg.Rect[3] > 0 {
+		x, y, w, h := cfg.Rect[0], cfg.Rect[1], cfg.Rect[2], cfg.Rect[3]
+		if cfg.Percent {
+			x, w = x*float64(r.Dx())/100, w*float64(r.Dx())/100
+			y, h = y*float64(r.Dy())/100, h*float64(r.Dy())/100
+		}
+		x0, y0 := r.Min.X+int(math.Round(x)), r.Min.Y+int(math.Round(y))
+		if rect := image.Rect(x0, y0, x0+max(int(math.Round(w)), 1), y0+max(int(math.Round(h)), 1)).Intersect(r); !rect.Empty() {
+			r = rect
+		}
+	}
+	if cfg.Aspect > 0 && !r.Empty() {
+		w, h := r.Dx(), r.Dy()
+		if float64(w)/float64(h) > cfg.Aspect {
+			w = max(int(math.Round(float64(h)*cfg.Aspect)), 1)
+		} else {
+			h = max(int(math.Round(float64(w)/cfg.Aspect)), 1)
+		}
+		r = cfg.Gravity.Place(r, w, h)
+	}
+	return r
+}
+
+// Crop returns a copy of the region of img selected by cfg, with its origin at (0, 0).
+func Crop(img image.Image, cfg CropCfg) image.Image {
+	r := CropRect(img.Bounds(), cfg)
+	if cfg.IsUsed && cfg.Aspect > 0 && cfg.Gravity == Smart {
+		// CropRect centers the window; move it within the region left by the explicit crop
+		region := CropRect(img.Bounds(), CropCfg{IsUsed: true, Rect: cfg.Rect, Percent: cfg.Percent})
+		r = SmartCrop(img, region, r.Dx(), r.Dy())
+	}
+	return cropTo(img, r)
+}
+
+// cropTo returns a copy of the region r of img, with its origin at (0, 0)
+func cropTo(img image.Image, r image.Rectangle) image.Image {
+	if r == img.Bounds() && r.Min == (image.Point{}) {
+		return img
+	}
+	dst := NewDstImage(img, r.Sub(r.Min), true)
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
@@ -7,19 +7,20 @@ import (
 	"strings"
 )
 
-func ParseDataUrl(u *url.URL) (string, error) {
+// returns the media type and the base64-encoded data of a data url
+func ParseDataUrl(u *url.URL) (string, string, error) {
 	opaque := u.Opaque
-	_, after, found := strings.Cut(opaque, ";")
+	mimeType, after, found := strings.Cut(opaque, ";")
 	if !found {
-		return *new(string), fmt.Errorf("unable to parse data url")
+		return *new(string), *new(string), fmt.Errorf("unable to parse data url")
 	}
 
 	before, after, found := strings.Cut(after, ",")
 	if !found {
-		return *new(string), fmt.Errorf("unable to parse data url image format")
+		return *new(string), *new(string), fmt.Errorf("unable to parse data url image format")
 	}
 	if before != "base64" {
-		return *new(string), fmt.Errorf("unable to parse data url encoding")
+		return *new(string), *new(string), fmt.Errorf("unable to parse data url encoding")
 	}
-	return after, nil
+	return mimeType, after, nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Is16Bit reports whether img stores more than 8 bits per channel.
+func Is16Bit(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return true
+	default:
+		return false
+	}
+}
+
+func isGray(img image.Image) bool {
+	m := img.ColorModel()
+	return m == color.GrayModel || m == color.Gray16Model
+}
+
+// To8Bit converts 16-bit images to their 8-bit equivalents; other images are returned unchanged.
+func To8Bit(img image.Image) image.Image {
+	if !Is16Bit(img) {
+		return img
+	}
+	var dst draw.Image
+	if isGray(img) {
+		dst = image.NewGray(img.Bounds())
+	} else {
+		dst = image.NewNRGBA(img.Bounds())
+	}
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// NewDstImage allocates an image with bounds r that can hold a processed copy of src.
+// 16-bit sources keep 16 bits per channel if highBitDepth is true; everything else is NRGBA.
+func NewDstImage(src image.Image, r image.Rectangle, highBitDepth bool) draw.Image {
+	if highBitDepth && Is16Bit(src) {
+		if isGray(src) {
+			return image.NewGray16(r)
+		}
+		return image.NewNRGBA64(r)
+	}
+	return image.NewNRGBA(r)
+}
@@ -4,41 +4,57 @@ import (
 	"fmt"
 	"image"
 	"image/draw"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
 	"io"
 
 	"golang.org/x/image/tiff"
-
-	"github.com/cdillond/imgconv/pkg/utils"
-	"github.com/cdillond/imgconv/pkg/webpenc"
 )
 
 type EncodeCfg struct {
-	FileType      utils.FileType
-	GifNumColors  int
-	GifQuantizer  draw.Quantizer
-	GifDrawer     draw.Drawer
-	JpegQuality   int
-	TiffCompType  tiff.CompressionType
-	TiffPredictor bool
-	WebPLossy     bool
-	WebPQuality   uint
+	Codec            *Codec
+	GifNumColors     int
+	GifQuantizer     draw.Quantizer
+	GifDrawer        draw.Drawer
+	JpegQuality      int
+	JpegLossless     bool
+	TiffCompType     tiff.CompressionType
+	TiffPredictor    bool
+	WebPLossy        bool
+	WebPQuality      uint
+	AvifQuality      uint
+	AvifAlphaQuality uint
+	AvifSpeed        int
+	AvifSubsample    string
+	AvifCodec        string
+	JxlDistance      float64
+	JxlEffort        int
+	JxlLossless      bool
+	JxlTranscodeJpeg bool
+	Force8Bit        bool
 }
 type EncodeOpt func(*EncodeCfg)
 
-func NewEncodeCfg(fileType utils.FileType, opts ...EncodeOpt) EncodeCfg {
+func NewEncodeCfg(codec *Codec, opts ...EncodeOpt) EncodeCfg {
 	cfg := EncodeCfg{
-		FileType:      fileType,
-		GifNumColors:  256,
-		GifQuantizer:  nil,
-		GifDrawer:     nil,
-		JpegQuality:   100,
-		TiffCompType:  0,
-		TiffPredictor: false,
-		WebPLossy:     false,
-		WebPQuality:   100,
+		Codec:            codec,
+		GifNumColors:     256,
+		GifQuantizer:     nil,
+		GifDrawer:        nil,
+		JpegQuality:      100,
+		JpegLossless:     true,
+		TiffCompType:     0,
+		TiffPredictor:    false,
+		WebPLossy:        false,
+		WebPQuality:      100,
+		AvifQuality:      60,
+		AvifAlphaQuality: 100,
+		AvifSpeed:        6,
+		AvifSubsample:    "420",
+		AvifCodec:        "",
+		JxlDistance:      1.0,
+		JxlEffort:        7,
+		JxlLossless:      false,
+		JxlTranscodeJpeg: true,
+		Force8Bit:        false,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -60,6 +76,13 @@ func WithJpegQuality(n int) func(*EncodeCfg) {
 	}
 }
 
+// if true, jpeg sources that are only rotated, flipped, or cropped are transformed without being re-encoded
+func WithJpegLossless(l bool) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		e.JpegLossless = l
+	}
+}
+
 func WithGifNumColors(n int) func(*EncodeCfg) {
 	return func(e *EncodeCfg) {
 		if n < 0 {
@@ -88,6 +111,106 @@ func WithWebPQual(u uint) func(*EncodeCfg) {
 	}
 }
 
+func WithAvifQual(u uint) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		if u > 100 {
+			e.AvifQuality = 100
+		} else {
+			e.AvifQuality = u
+		}
+	}
+}
+
+func WithAvifAlphaQual(u uint) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		if u > 100 {
+			e.AvifAlphaQuality = 100
+		} else {
+			e.AvifAlphaQuality = u
+		}
+	}
+}
+
+// negative values select the codec default
+func WithAvifSpeed(n int) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		if n < 0 {
+			e.AvifSpeed = -1
+		} else if n > 10 {
+			e.AvifSpeed = 10
+		} else {
+			e.AvifSpeed = n
+		}
+	}
+}
+
+// unrecognized values are ignored
+func WithAvifSubsample(s string) func(*EncodeCfg) {
+	switch s {
+	case "444", "422", "420", "400":
+		return func(e *EncodeCfg) {
+			e.AvifSubsample = s
+		}
+	default:
+		return func(*EncodeCfg) {}
+	}
+}
+
+// unrecognized values are ignored
+func WithAvifCodec(s string) func(*EncodeCfg) {
+	switch s {
+	case "aom", "rav1e", "svt", "":
+		return func(e *EncodeCfg) {
+			e.AvifCodec = s
+		}
+	default:
+		return func(*EncodeCfg) {}
+	}
+}
+
+func WithJxlDistance(f float64) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		if f < 0 {
+			e.JxlDistance = 0
+		} else if f > 15 {
+			e.JxlDistance = 15
+		} else {
+			e.JxlDistance = f
+		}
+	}
+}
+
+func WithJxlEffort(n int) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		if n < 1 {
+			e.JxlEffort = 1
+		} else if n > 9 {
+			e.JxlEffort = 9
+		} else {
+			e.JxlEffort = n
+		}
+	}
+}
+
+func WithJxlLossless(l bool) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		e.JxlLossless = l
+	}
+}
+
+func WithJxlTranscodeJpeg(t bool) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		e.JxlTranscodeJpeg = t
+	}
+}
+
+// if true, 16-bit images are reduced to 8 bits per channel before encoding
+func WithForce8Bit(f bool) func(*EncodeCfg) {
+	return func(e *EncodeCfg) {
+		e.Force8Bit = f
+	}
+}
+
 /*
 TO DO
 func WithGifQuantizer(q draw.Quantizer) func(*EncodeCfg) {
@@ -98,25 +221,13 @@ func WithGifQuantizer(q draw.Quantizer) func(*EncodeCfg) {
 */
 
 func Encode(img image.Image, w io.Writer, cfg EncodeCfg) error {
-	switch cfg.FileType {
-	case utils.GIF:
-		return gif.Encode(w, img, &gif.Options{
-			NumColors: cfg.GifNumColors,
-			Quantizer: cfg.GifQuantizer,
-			Drawer:    cfg.GifDrawer})
-	case utils.JPEG:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: cfg.JpegQuality})
-	case utils.PNG:
-		return png.Encode(w, img)
-	case utils.TIFF:
-		return tiff.Encode(w, img, &tiff.Options{
-			Compression: cfg.TiffCompType,
-			Predictor:   cfg.TiffPredictor})
-	case utils.WEBP:
-		return webpenc.EncodeWebP(w, img, webpenc.WebPOptions{IsLossy: cfg.WebPLossy, Quality: cfg.WebPQuality})
-	default:
+	if cfg.Codec == nil || !cfg.Codec.CanEncode() {
 		return fmt.Errorf("unsupported file type")
 	}
+	if cfg.Force8Bit {
+		img = To8Bit(img)
+	}
+	return cfg.Codec.Encode(w, img, cfg)
 }
 
 /*
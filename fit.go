@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FitMode determines how an image is sized to a box, like the CSS object-fit property.
+type FitMode int
+
+const (
+	FitNone FitMode = iota
+	// FitCover scales the image to cover the box, preserving its proportions, and crops the overflow.
+	FitCover
+	// FitContain scales the image to fit inside the box, preserving its proportions, and pads the rest of the box.
+	FitContain
+	// FitFill stretches the image to the size of the box.
+	FitFill
+	// FitInside scales the image to fit inside the box, preserving its proportions; the result may be smaller than the box.
+	FitInside
+	// FitOutside scales the image to cover the box, preserving its proportions; the result may be larger than the box.
+	FitOutside
+)
+
+var fitModeNames = []string{"none", "cover", "contain", "fill", "inside", "outside"}
+
+func (f FitMode) String() string {
+	if int(f) < len(fitModeNames) {
+		return fitModeNames[f]
+	}
+	return fmt.Sprintf("FitMode(%d)", int(f))
+}
+
+// ParseFitMode parses the name of a fit mode other than FitNone, which is the absence of one.
+func ParseFitMode(s string) (FitMode, error) {
+	for i, name := range fitModeNames[FitNone+1:] {
+		if strings.EqualFold(s, name) {
+			return FitNone + 1 + FitMode(i), nil
+		}
+	}
+	return FitNone, fmt.Errorf("unknown fit mode %q; options are cover, contain, fill, inside, and outside", s)
+}
+
+// ParseSize parses a box size given as WxH, e.g. 800x600.
+func ParseSize(s string) (int, int, error) {
+	ws, hs, found := strings.Cut(strings.ToLower(s), "x")
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if !found || errW != nil || errH != nil || w < 1 || h < 1 {
+		return 0, 0, fmt.Errorf("invalid size %q; expected WxH, e.g. 800x600", s)
+	}
+	return w, h, nil
+}
+
+type FitCfg struct {
+	Mode    FitMode
+	Width   int
+	Height  int
+	Gravity Gravity     // the part of the image kept by cover, and the position of the image in the box for contain
+	Pad     color.Color // the background of the box for contain
+}
+
+// fitScaledRect returns the size an image of size src is scaled to before cover crops it or contain pads it
+func fitScaledRect(src image.Point, cfg FitCfg) image.Rectangle {
+	if cfg.Mode == FitFill {
+		return image.Rect(0, 0, cfg.Width, cfg.Height)
+	}
+	rx := float64(cfg.Width) / float64(src.X)
+	ry := float64(cfg.Height) / float64(src.Y)
+	r := math.Min(rx, ry)
+	if cfg.Mode == FitCover || cfg.Mode == FitOutside {
+		r = math.Max(rx, ry)
+	}
+	w := max(int(math.Round(float64(src.X)*r)), 1)
+	h := max(int(math.Round(float64(src.Y)*r)), 1)
+	return image.Rect(0, 0, w, h)
+}
+
+// fitBox returns the final size of a scaled image of size scaled
+func fitBox(scaled image.Rectangle, cfg FitCfg) image.Rectangle {
+	switch cfg.Mode {
+	case FitCover, FitContain:
+		return image.Rect(0, 0, cfg.Width, cfg.Height)
+	default:
+		return scaled
+	}
+}
+
+// applyFit crops (cover) or pads (contain) a scaled image to the size of the box
+func applyFit(img image.Image, cfg FitCfg, highBitDepth bool) image.Image {
+	b := img.Bounds()
+	box := image.Rect(0, 0, cfg.Width, cfg.Height)
+	switch cfg.Mode {
+	case FitCover:
+		if b.Size() == box.Size() {
+			return img
+		}
+		r := cfg.Gravity.Place(b, cfg.Width, cfg.Height)
+		if cfg.Gravity == Smart {
+			r = SmartCrop(img, b, cfg.Width, cfg.Height)
+		}
+		dst := NewDstImage(img, box, highBitDepth)
+		draw.Draw(dst, box, img, r.Min, draw.Src)
+		return dst
+	case FitContain:
+		if b.Size() == box.Size() {
+			return img
+		}
+		dst := NewDstImage(img, box, highBitDepth)
+		pad := cfg.Pad
+		if pad == nil {
+			pad = color.Transparent
+		}
+		draw.Draw(dst, box, image.NewUniform(pad), image.Point{}, draw.Src)
+		draw.Draw(dst, cfg.Gravity.Place(box, b.Dx(), b.Dy()), img, b.Min, draw.Over)
+		return dst
+	default:
+		return img
+	}
+}
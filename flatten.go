@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// the size and colors of the squares drawn by FlattenCfg.Checkerboard
+const checkerSize = 8
+
+var checkerColors = [2]color.NRGBA{{0xff, 0xff, 0xff, 0xff}, {0xcc, 0xcc, 0xcc, 0xff}}
+
+type FlattenCfg struct {
+	IsUsed       bool
+	Background   color.Color // transparent parts of the background are treated as white
+	Checkerboard bool        // draw a checkerboard instead of Background, e.g. for previews
+}
+
+// NewFlattenCfg returns a FlattenCfg for background, which is a color as accepted by ParseColor or checkerboard.
+func NewFlattenCfg(background string) (FlattenCfg, error) {
+	if strings.EqualFold(background, "checkerboard") {
+		return FlattenCfg{IsUsed: true, Checkerboard: true}, nil
+	}
+	c, err := ParseColor(background)
+	if err != nil {
+		return FlattenCfg{}, err
+	}
+	return FlattenCfg{IsUsed: true, Background: c}, nil
+}
+
+// Flatten composites img over an opaque background, so that formats without an alpha channel do not show
+// whatever color happens to be stored under transparent pixels. Opaque images are returned unchanged.
+func Flatten(img image.Image, cfg FlattenCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	if isOpaque(img) {
+		return img
+	}
+	b := img.Bounds()
+	dst := NewDstImage(img, b.Sub(b.Min), true)
+	if cfg.Checkerboard {
+		for y := 0; y < b.Dy(); y += checkerSize {
+			for x := 0; x < b.Dx(); x += checkerSize {
+				c := checkerColors[(x/checkerSize+y/checkerSize)%2]
+				draw.Draw(dst, image.Rect(x, y, x+checkerSize, y+checkerSize), image.NewUniform(c), image.Point{}, draw.Src)
+			}
+		}
+	} else {
+		// draw the background over white, so that it is opaque even if it is (partly) transparent
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		if cfg.Background != nil {
+			draw.Draw(dst, dst.Bounds(), image.NewUniform(cfg.Background), image.Point{}, draw.Over)
+		}
+	}
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// isOpaque reports whether img is known to be fully opaque
+func isOpaque(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
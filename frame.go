@@ -0,0 +1,189 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+type FrameCfg struct {
+	IsUsed       bool
+	Padding      int         // pixels added around the image, inside the border
+	PaddingColor color.Color // nil is transparent
+	BorderWidth  int
+	BorderColor  color.Color
+	BorderColor2 color.Color // if not nil, the border fades from BorderColor at the top to BorderColor2 at the bottom
+	Radius       float64     // the radius of the rounded outer corners in pixels; the corners outside it are transparent
+	ShadowColor  color.Color // nil draws no drop shadow
+	ShadowOffset image.Point
+	ShadowBlur   float64 // the standard deviation of the blur of the shadow, in pixels
+	Threads      int
+}
+
+func NewFrameCfg(opts ...FrameOpt) FrameCfg {
+	cfg := FrameCfg{
+		IsUsed:      false,
+		BorderColor: color.Black,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type FrameOpt func(*FrameCfg)
+
+// adds px pixels of c around the image; a nil c is transparent, and values < 1 are ignored
+func WithPadding(px int, c color.Color) func(*FrameCfg) {
+	if px < 1 {
+		return func(*FrameCfg) {}
+	}
+	return func(f *FrameCfg) {
+		f.IsUsed = true
+		f.Padding, f.PaddingColor = px, c
+	}
+}
+
+// draws a border of width pixels around the image and its padding; if to is not nil, the border is a vertical
+// gradient from c to to; values < 1 are ignored
+func WithBorder(width int, c, to color.Color) func(*FrameCfg) {
+	if width < 1 {
+		return func(*FrameCfg) {}
+	}
+	return func(f *FrameCfg) {
+		f.IsUsed = true
+		f.BorderWidth, f.BorderColor, f.BorderColor2 = width, c, to
+	}
+}
+
+// rounds the outer corners of the image, border included; values <= 0 are ignored
+func WithRoundedCorners(radius float64) func(*FrameCfg) {
+	if radius <= 0 {
+		return func(*FrameCfg) {}
+	}
+	return func(f *FrameCfg) {
+		f.IsUsed = true
+		f.Radius = radius
+	}
+}
+
+// draws a shadow of the framed image in c, moved by offset and blurred with a Gaussian kernel of blur pixels;
+// the canvas grows to hold it
+func WithDropShadow(c color.Color, offset image.Point, blur float64) func(*FrameCfg) {
+	if c == nil {
+		return func(*FrameCfg) {}
+	}
+	return func(f *FrameCfg) {
+		f.IsUsed = true
+		f.ShadowColor, f.ShadowOffset, f.ShadowBlur = c, offset, max(blur, 0)
+	}
+}
+
+// the number of goroutines used to blur the drop shadow; values < 1 use all CPUs
+func WithFrameThreads(n int) func(*FrameCfg) {
+	return func(f *FrameCfg) {
+		f.Threads = n
+	}
+}
+
+// roundedMask returns the antialiased coverage of a w x h rectangle with corners of radius r
+func roundedMask(w, h int, r float64) *image.Alpha {
+	m := image.NewAlpha(image.Rect(0, 0, w, h))
+	r = min(r, float64(w)/2, float64(h)/2)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// the distance from the center of the pixel to the nearest corner circle center, if it is in a corner
+			px, py := float64(x)+0.5, float64(y)+0.5
+			cx, cy := min(max(px, r), float64(w)-r), min(max(py, r), float64(h)-r)
+			cov := 1.0
+			if px != cx && py != cy {
+				cov = min(max(r-math.Hypot(px-cx, py-cy)+0.5, 0), 1)
+			}
+			m.Pix[y*m.Stride+x] = uint8(cov*0xff + 0.5)
+		}
+	}
+	return m
+}
+
+// verticalGradient returns a w x h image that fades from c0 at the top to c1 at the bottom
+func verticalGradient(w, h int, c0, c1 color.Color) *image.NRGBA64 {
+	m := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	a := color.NRGBA64Model.Convert(c0).(color.NRGBA64)
+	b := color.NRGBA64Model.Convert(c1).(color.NRGBA64)
+	mix := func(u, v uint16, t float64) uint16 {
+		return clampChannel(float64(u)*(1-t) + float64(v)*t)
+	}
+	for y := 0; y < h; y++ {
+		t := 0.0
+		if h > 1 {
+			t = float64(y) / float64(h-1)
+		}
+		c := color.NRGBA64{mix(a.R, b.R, t), mix(a.G, b.G, t), mix(a.B, b.B, t), mix(a.A, b.A, t)}
+		draw.Draw(m, image.Rect(0, y, w, y+1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	return m
+}
+
+// Frame returns a copy of img with the padding, border, rounded corners, and drop shadow in cfg, with its
+// origin at (0, 0). The parts of the result outside of the rounded corners and around the shadow are transparent,
+// so images written to formats without alpha should be flattened afterwards (see Flatten).
+func Frame(img image.Image, cfg FrameCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	b := img.Bounds()
+	p, bw := max(cfg.Padding, 0), max(cfg.BorderWidth, 0)
+	w, h := b.Dx()+2*(p+bw), b.Dy()+2*(p+bw)
+	var framed draw.Image = image.NewNRGBA(image.Rect(0, 0, w, h))
+	if Is16Bit(img) {
+		framed = image.NewNRGBA64(framed.Bounds())
+	}
+
+	// the content: the image on its padding, clipped to the inner corners
+	inner := image.Rect(bw, bw, w-bw, h-bw)
+	content := image.NewNRGBA64(inner.Sub(inner.Min))
+	if cfg.PaddingColor != nil {
+		draw.Draw(content, content.Bounds(), image.NewUniform(cfg.PaddingColor), image.Point{}, draw.Src)
+	}
+	draw.Draw(content, content.Bounds().Inset(p), img, b.Min, draw.Over)
+	innerMask := roundedMask(inner.Dx(), inner.Dy(), max(cfg.Radius-float64(bw), 0))
+	draw.DrawMask(framed, inner, content, image.Point{}, innerMask, image.Point{}, draw.Over)
+
+	// the border: the ring between the outer and inner corners
+	if bw > 0 {
+		outerMask := roundedMask(w, h, cfg.Radius)
+		for y := inner.Min.Y; y < inner.Max.Y; y++ {
+			for x := inner.Min.X; x < inner.Max.X; x++ {
+				i := outerMask.PixOffset(x, y)
+				outerMask.Pix[i] = uint8(uint32(outerMask.Pix[i]) * uint32(0xff-innerMask.Pix[innerMask.PixOffset(x-bw, y-bw)]) / 0xff)
+			}
+		}
+		var border image.Image = image.NewUniform(cfg.BorderColor)
+		if cfg.BorderColor2 != nil {
+			border = verticalGradient(w, h, cfg.BorderColor, cfg.BorderColor2)
+		}
+		draw.DrawMask(framed, framed.Bounds(), border, image.Point{}, outerMask, image.Point{}, draw.Over)
+	}
+
+	if cfg.ShadowColor == nil {
+		return framed
+	}
+	// grow the canvas to hold the blurred shadow, and draw the framed image over it
+	margin := int(math.Ceil(3 * cfg.ShadowBlur))
+	fr := framed.Bounds()
+	sr := fr.Add(cfg.ShadowOffset).Inset(-margin)
+	canvas := fr.Union(sr)
+	shadow := image.NewAlpha(canvas.Sub(canvas.Min))
+	draw.Draw(shadow, fr.Sub(canvas.Min).Add(cfg.ShadowOffset), framed, image.Point{}, draw.Src)
+	if cfg.ShadowBlur > 0 {
+		shadow = blurMask(shadow, cfg.ShadowBlur, cfg.Threads)
+	}
+	var dst draw.Image = image.NewNRGBA(shadow.Bounds())
+	if Is16Bit(img) {
+		dst = image.NewNRGBA64(shadow.Bounds())
+	}
+	draw.DrawMask(dst, dst.Bounds(), image.NewUniform(cfg.ShadowColor), image.Point{}, shadow, image.Point{}, draw.Src)
+	draw.Draw(dst, fr.Sub(canvas.Min), framed, image.Point{}, draw.Over)
+	return dst
+}
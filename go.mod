@@ -6,3 +6,5 @@ require (
 	github.com/google/uuid v1.3.1
 	golang.org/x/image v0.12.0
 )
+
+require golang.org/x/text v0.13.0 // indirect
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Gravity determines where a smaller rectangle is placed inside a larger one.
+type Gravity int
+
+const (
+	Center Gravity = iota
+	North
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+	// Smart crops to the most interesting part of the image (see SmartCrop); it is placed like Center otherwise.
+	Smart
+)
+
+var gravityNames = []string{"center", "north", "northeast", "east", "southeast", "south", "southwest", "west", "northwest", "smart"}
+
+func (g Gravity) String() string {
+	if int(g) < len(gravityNames) {
+		return gravityNames[g]
+	}
+	return fmt.Sprintf("Gravity(%d)", int(g))
+}
+
+// ParseGravity accepts the compass directions (e.g. north or northwest), center, and smart; names are not case sensitive.
+func ParseGravity(s string) (Gravity, error) {
+	for i, name := range gravityNames {
+		if strings.EqualFold(s, name) {
+			return Gravity(i), nil
+		}
+	}
+	return Center, fmt.Errorf("unknown gravity %q; options are %s", s, strings.Join(gravityNames, ", "))
+}
+
+// Place returns a w x h rectangle positioned inside r according to g.
+// If the rectangle is larger than r, it overhangs r evenly on the sides that g does not pin.
+func (g Gravity) Place(r image.Rectangle, w, h int) image.Rectangle {
+	x := r.Min.X + (r.Dx()-w)/2
+	y := r.Min.Y + (r.Dy()-h)/2
+	switch g {
+	case North, NorthEast, NorthWest:
+		y = r.Min.Y
+	case South, SouthEast, SouthWest:
+		y = r.Max.Y - h
+	}
+	switch g {
+	case West, NorthWest, SouthWest:
+		x = r.Min.X
+	case East, NorthEast, SouthEast:
+		x = r.Max.X - w
+	}
+	return image.Rect(x, y, x+w, y+h)
+}
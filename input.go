@@ -7,36 +7,31 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
-
-	_ "golang.org/x/image/tiff"
-	_ "golang.org/x/image/webp"
-
-	"github.com/cdillond/imgconv/pkg/utils"
 )
 
-func DecodeLocal(srcUrl string) (image.Image, utils.FileType, error) {
+func DecodeLocal(srcUrl string) (image.Image, *Codec, error) {
 	f, err := os.Open(srcUrl)
 	if err != nil {
-		return nil, utils.UNSUPPORTED, err
+		return nil, nil, err
 	}
-	img, format, err := image.Decode(f)
+	declared := ExtFormat(srcUrl)
+	img, codec, err := decodeWithHint(f, declared)
+	CheckFormat(srcUrl, filepath.Ext(srcUrl), declared, codec)
 	if err == nil {
-		return img, utils.StringToFileType(format), f.Close()
+		return img, codec, f.Close()
 	}
 	f.Close() // otherwise, ignore this error
-	return img, utils.StringToFileType(format), err
+	return img, codec, err
 }
 
-func DecodeRemote(u string) (image.Image, utils.FileType, error) {
+func DecodeRemote(u string) (image.Image, *Codec, error) {
 	srcUrl, err := url.Parse(u)
 	if err != nil {
-		return nil, utils.UNSUPPORTED, err
+		return nil, nil, err
 	}
 
 	// prevent client from following redirects
@@ -61,43 +56,53 @@ func DecodeRemote(u string) (image.Image, utils.FileType, error) {
 				resp.Body.Close()
 				continue
 			}
-			img, format, err := image.Decode(resp.Body)
-			resp.Body.Close()
+			img, codec, err := decodeResponse(srcUrl.String(), resp)
 			if err == nil {
-				return img, utils.StringToFileType(format), err
+				return img, codec, err
 			}
 		}
-		return nil, utils.UNSUPPORTED, fmt.Errorf("could not infer scheme from incomplete url: %s", u)
+		return nil, nil, fmt.Errorf("could not infer scheme from incomplete url: %s", u)
 	}
 	if srcUrl.Scheme == "data" {
-		src, err := ParseDataUrl(srcUrl)
+		mimeType, src, err := ParseDataUrl(srcUrl)
 		if err != nil {
-			return nil, utils.UNSUPPORTED, err
+			return nil, nil, err
 		}
 		reader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(src))
-		img, format, err := image.Decode(reader)
+		declared := MIMEFormat(mimeType)
+		img, codec, err := decodeWithHint(reader, declared)
+		CheckFormat("data url", mimeType, declared, codec)
 		if err != nil {
-			return img, utils.UNSUPPORTED, err
+			return img, nil, err
 		}
-		return img, utils.StringToFileType(format), ErrDataURL
+		return img, codec, ErrDataURL
 	}
 	if srcUrl.Scheme != "https" && srcUrl.Scheme != "http" {
-		return nil, utils.UNSUPPORTED, fmt.Errorf("unsupported url scheme: %s", srcUrl.Scheme)
+		return nil, nil, fmt.Errorf("unsupported url scheme: %s", srcUrl.Scheme)
 	}
 
 	resp, err := client.Get(srcUrl.String())
 	if err != nil {
-		return nil, utils.UNSUPPORTED, err
+		return nil, nil, err
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		// ignore responses with non-2XX status codes
 		io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
-		return nil, utils.UNSUPPORTED, fmt.Errorf("request failed with status %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("request failed with status %d", resp.StatusCode)
 	}
 
-	img, format, err := image.Decode(resp.Body)
-	resp.Body.Close()
-	return img, utils.StringToFileType(format), err
+	return decodeResponse(srcUrl.String(), resp)
+}
+
+// decodeResponse decodes and closes the body of resp, using its Content-Type as a fallback
+// if the format cannot be identified from the body's magic bytes
+func decodeResponse(u string, resp *http.Response) (image.Image, *Codec, error) {
+	defer resp.Body.Close()
+	contentType := resp.Header.Get("Content-Type")
+	declared := MIMEFormat(contentType)
+	img, codec, err := decodeWithHint(resp.Body, declared)
+	CheckFormat(u, contentType, declared, codec)
+	return img, codec, err
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Additional resampling filters. All but Area are draw.Kernels, so they work with
+// anything that accepts a draw.Interpolator.
+var (
+	// Lanczos2 is a windowed sinc filter with 2 lobes; it is a little softer than Lanczos3, but rings less.
+	Lanczos2 = &draw.Kernel{Support: 2, At: lanczos(2)}
+	// Lanczos3 is a windowed sinc filter with 3 lobes; it is the sharpest of the kernels.
+	Lanczos3 = &draw.Kernel{Support: 3, At: lanczos(3)}
+	// Mitchell is the Mitchell-Netravali cubic filter (B = C = 1/3), a compromise between blurring and ringing.
+	Mitchell = &draw.Kernel{Support: 2, At: bicubic(1.0/3, 1.0/3)}
+	// Hermite is a smooth cubic filter with no negative lobes.
+	Hermite = &draw.Kernel{Support: 1, At: func(t float64) float64 {
+		return (2*t-3)*t*t + 1
+	}}
+	// Gaussian is a Gaussian filter with a standard deviation of 1/2 pixel; it blurs slightly, but never rings.
+	Gaussian = &draw.Kernel{Support: 2, At: func(t float64) float64 {
+		return math.Exp(-2 * t * t)
+	}}
+	// Box averages the source pixels covered by the destination pixel; the edge of the box
+	// is given half weight so that pixels exactly halfway between two samples are still covered.
+	Box = &draw.Kernel{Support: 1, At: func(t float64) float64 {
+		if t < 0.5 {
+			return 1
+		}
+		if t == 0.5 {
+			return 0.5
+		}
+		return 0
+	}}
+	// Area computes the exact average of the source area covered by each destination pixel.
+	// It is the best choice for large reduction ratios.
+	Area draw.Interpolator = areaInterpolator{}
+)
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func lanczos(a float64) func(float64) float64 {
+	return func(t float64) float64 {
+		return sinc(t) * sinc(t/a)
+	}
+}
+
+// bicubic returns the Mitchell-Netravali family of cubic filters
+func bicubic(b, c float64) func(float64) float64 {
+	return func(t float64) float64 {
+		if t < 1 {
+			return ((12-9*b-6*c)*t*t*t + (-18+12*b+6*c)*t*t + (6 - 2*b)) / 6
+		}
+		return ((-b-6*c)*t*t*t + (6*b+30*c)*t*t + (-12*b-48*c)*t + (8*b + 24*c)) / 6
+	}
+}
+
+var interpolators = map[string]draw.Interpolator{
+	"catmullrom":      draw.CatmullRom,
+	"nearestneighbor": draw.NearestNeighbor,
+	"approxbilinear":  draw.ApproxBiLinear,
+	"bilinear":        draw.BiLinear,
+	"lanczos2":        Lanczos2,
+	"lanczos3":        Lanczos3,
+	"mitchell":        Mitchell,
+	"hermite":         Hermite,
+	"gaussian":        Gaussian,
+	"box":             Box,
+	"area":            Area,
+}
+
+// InterpolatorNames returns the names accepted by ParseInterpolator
+func InterpolatorNames() []string {
+	names := []string{"CatmullRom", "NearestNeighbor", "ApproxBiLinear", "BiLinear", "Lanczos2", "Lanczos3", "Mitchell", "Hermite", "Gaussian", "Box", "Area"}
+	sort.Strings(names)
+	return names
+}
+
+// ParseInterpolator returns the interpolator with the given name; names are not case sensitive.
+func ParseInterpolator(s string) (draw.Interpolator, error) {
+	interp, ok := interpolators[strings.ToLower(s)]
+	if !ok {
+		return nil, fmt.Errorf("unknown interpolator %q; options are %s", s, strings.Join(InterpolatorNames(), ", "))
+	}
+	return interp, nil
+}
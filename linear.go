@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// lookup tables between 16-bit sRGB-encoded and 16-bit linear light values
+var (
+	toLinearLUT   [1 << 16]uint16
+	fromLinearLUT [1 << 16]uint16
+	lutOnce       sync.Once
+)
+
+func initLUTs() {
+	for i := range toLinearLUT {
+		v := float64(i) / 0xffff
+		var lin float64
+		if v <= 0.04045 {
+			lin = v / 12.92
+		} else {
+			lin = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		toLinearLUT[i] = uint16(lin*0xffff + 0.5)
+
+		var enc float64
+		if v <= 0.0031308 {
+			enc = v * 12.92
+		} else {
+			enc = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		fromLinearLUT[i] = uint16(enc*0xffff + 0.5)
+	}
+}
+
+// ToLinear converts src to an RGBA64 image whose channels hold linear light values. Like all RGBA64
+// images, the result is alpha-premultiplied, so resampling it does not darken the edges of transparent areas.
+func ToLinear(src image.Image) *image.RGBA64 {
+	lutOnce.Do(initLUTs)
+	b := src.Bounds()
+	lin := image.NewRGBA64(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+			a := uint32(c.A)
+			i := lin.PixOffset(x, y)
+			s := lin.Pix[i : i+8 : i+8]
+			r := uint32(toLinearLUT[c.R]) * a / 0xffff
+			g := uint32(toLinearLUT[c.G]) * a / 0xffff
+			bl := uint32(toLinearLUT[c.B]) * a / 0xffff
+			s[0], s[1] = uint8(r>>8), uint8(r)
+			s[2], s[3] = uint8(g>>8), uint8(g)
+			s[4], s[5] = uint8(bl>>8), uint8(bl)
+			s[6], s[7] = uint8(a>>8), uint8(a)
+		}
+	}
+	return lin
+}
+
+// FromLinear converts a linear light image produced by ToLinear back to sRGB, storing the result in dst.
+func FromLinear(dst draw.Image, lin *image.RGBA64) {
+	lutOnce.Do(initLUTs)
+	b := dst.Bounds().Intersect(lin.Bounds())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := lin.RGBA64At(x, y)
+			if c.A == 0 {
+				dst.Set(x, y, color.NRGBA64{})
+				continue
+			}
+			a := uint32(c.A)
+			// un-premultiply; resampling filters with negative lobes can push channels past alpha
+			r := min(uint32(c.R)*0xffff/a, 0xffff)
+			g := min(uint32(c.G)*0xffff/a, 0xffff)
+			bl := min(uint32(c.B)*0xffff/a, 0xffff)
+			dst.Set(x, y, color.NRGBA64{fromLinearLUT[r], fromLinearLUT[g], fromLinearLUT[bl], c.A})
+		}
+	}
+}
@@ -7,13 +7,10 @@ import (
 	"image"
 	"log"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/cdillond/imgconv/pkg/utils"
-	"github.com/cdillond/imgconv/pkg/webpenc"
-
 	"github.com/google/uuid"
 )
 
@@ -22,7 +19,7 @@ var ErrDataURL = errors.New("data url")
 func main() {
 	mode := flag.String("mode", "", "[REQUIRED] local, remote, or dir")
 	srcUrl := flag.String("url", "", "[REQUIRED] the url of the source image or, if -mode=dir, the path of the target directory")
-	toFileType := flag.String("to", "", "[REQUIRED] the file format of the output image; gif, jpeg, png, and tiff are supported")
+	toFileType := flag.String("to", "", "[REQUIRED] the file format of the output image; "+EncoderNames()+" are supported")
 	dstDir := flag.String("dstDir", "", "the path of the destination directory; if not specified, the current working directory will be used")
 	dstFileName := flag.String("out", "", "the path of the output file; if not specified, the source file name (with an updated extension) will be used (see docs for exceptions); if the path is absolute, it overrides dstDir, but, otherwise, it is relative to dstDir (if specified) or the current working directory; cannot be used in dir mode")
 	maxSidePixels := flag.Int("maxSidePixels", -1, "size of the greatest dimension of the output image rectangle in pixels; preserves the proportions of the source image")
@@ -32,13 +29,82 @@ func main() {
 	height := flag.Int("height", -1, "height of the output image in pixels; does not preserve the proportions of the source image")
 	width := flag.Int("width", -1, "width of the output image in pixels; does not preserve the proportions of the source image")
 	allowUpsize := flag.Bool("allowUpsize", false, "permit image pixel dimensions to increase when resizing")
-	jpegQuality := flag.Uint("jpegQual", 100, "the image quality of output jpeg files; accepted values are 0-100 (low - high)")
-	gifNumColors := flag.Uint("gifNumColors", 256, "the maximum number of colors in output gif files; accepted values are 1-256")
-	interpolator := flag.String("interpolator", "", "the interpolation algorithm used to resample images; options are CatmullRom (default, low speed/high quality), NearestNeighbor (high speed/low quality), and ApproxBiLinear (medium speed/medium quality)")
+	interpolator := flag.String("interpolator", "", "the interpolation algorithm used to resample images; options are CatmullRom (default, low speed/high quality), NearestNeighbor (high speed/low quality), ApproxBiLinear (medium speed/medium quality), BiLinear, Lanczos2, Lanczos3, Mitchell, Hermite, Gaussian, Box, and Area (best for large reductions)")
+	resizeMode := flag.String("resizeMode", "scale", "how images are resized; options are scale (resample with -interpolator) and seam (seam carving, which changes the aspect ratio by removing or inserting low-detail seams instead of cropping or stretching)")
+	seamMask := flag.String("seamMask", "", "the path of a mask image for -resizeMode=seam; bright areas of the mask are protected from removal; the mask is stretched to the size of each image")
+	linear := flag.Bool("linear", false, "if true, images are resampled in linear light rather than in sRGB, which preserves the brightness of fine detail when downscaling")
+	pyramidRatio := flag.Float64("pyramidRatio", 4, "images reduced by at least this ratio are first halved repeatedly with a fast box filter, which is faster and reduces aliasing; 0 disables this")
+	threads := flag.Int("threads", 0, "the number of threads used to resample and filter each image; if less than 1, all CPUs are used")
+	fit := flag.String("fit", "", "how the image is sized to the -size box; options are cover (fill the box and crop the overflow), contain (fit inside the box and pad the rest with -padColor), fill (stretch to the box), inside (fit inside the box), and outside (cover the box)")
+	size := flag.String("size", "", "the box used by -fit, as WxH, e.g. 800x600")
+	padColor := flag.String("padColor", "transparent", "the background color used by -fit=contain, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white)")
+	crop := flag.String("crop", "", "crop the image to x,y,w,h in pixels, or to x%,y%,w%,h% in percent of the image dimensions, e.g. 10,10,640,480 or 0%,0%,50%,50%")
+	cropAspect := flag.String("cropAspect", "", "crop the image to the largest region with the given aspect ratio, as w:h or a number, e.g. 16:9 or 1.5; applied after -crop")
+	gravity := flag.String("gravity", "center", "the part of the image kept by -cropAspect and -fit=cover, and the position of the image for -fit=contain; options are center, north, northeast, east, southeast, south, southwest, west, northwest, and smart (content-aware; crops only)")
+	cropAfterScale := flag.Bool("cropAfterScale", false, "if true, -crop and -cropAspect are applied to the scaled image rather than the source image")
+	rotate := flag.Float64("rotate", 0, "rotate the image clockwise by this many degrees; multiples of 90 are exact, and other angles are resampled with -interpolator")
+	flip := flag.String("flip", "", "mirror the image; options are horizontal, vertical, and both")
+	transpose := flag.Bool("transpose", false, "if true, the image is mirrored along its top-left to bottom-right diagonal")
+	transverse := flag.Bool("transverse", false, "if true, the image is mirrored along its top-right to bottom-left diagonal")
+	rotateBg := flag.String("rotateBg", "transparent", "the color of the corners uncovered by -rotate, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white)")
+	rotateExpand := flag.Bool("rotateExpand", true, "if true, the canvas grows to hold the whole image rotated by -rotate; otherwise, the image keeps its size and the corners are cut off; multiples of 90 degrees always keep the whole image")
+	trim := flag.String("trim", "", "remove uniform borders before cropping and resizing; options are topleft (borders of the color of the top-left pixel), alpha (transparent borders), or a color, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white)")
+	trimFuzz := flag.Float64("trimFuzz", 0, "how far, in percent, a pixel may be from the -trim color (or from transparent) and still be removed; accepted values are 0-100")
+	background := flag.String("background", "white", "the color transparent images are flattened onto when the output format does not support transparency (e.g. jpeg) or -flatten is set, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white), or checkerboard")
+	flatten := flag.Bool("flatten", false, "if true, transparent images are flattened onto -background even if the output format supports transparency")
+	brightness := flag.Float64("brightness", 0, "change the brightness of the image by this many percent; accepted values are -100-100")
+	contrast := flag.Float64("contrast", 0, "change the contrast of the image by this many percent; accepted values are -100-100")
+	gamma := flag.Float64("gamma", 1, "the gamma correction applied to the image; values greater than 1 brighten the midtones, and values less than 1 darken them")
+	saturation := flag.Float64("saturation", 0, "change the saturation of the image by this many percent; accepted values are -100-100 (grayscale - double saturation)")
+	hue := flag.Float64("hue", 0, "rotate the hue of every color in the image by this many degrees")
+	autoLevels := flag.Bool("autoLevels", false, "if true, the range of colors in the image is stretched to use the full range from black to white")
+	blur := flag.Float64("blur", 0, "blur the image with a Gaussian kernel with this standard deviation in pixels, after resizing")
+	boxBlur := flag.Int("boxBlur", 0, "blur the image by averaging the pixels within this many pixels in each direction, after resizing")
+	kernel := flag.String("kernel", "", "convolve the image with a custom kernel, as rows of comma-separated numbers separated by semicolons, e.g. 0,-1,0;-1,5,-1;0,-1,0; kernels must have odd dimensions and are normalized by their sum unless it is 0")
+	edge := flag.Bool("edge", false, "if true, the edges in the image are detected with a 3x3 Laplacian kernel")
+	emboss := flag.Bool("emboss", false, "if true, the image is embossed with a 3x3 kernel")
+	sharpen := flag.Bool("sharpen", false, "if true, the image is sharpened with a 3x3 kernel, after resizing")
+	unsharp := flag.String("unsharp", "", "sharpen the image with an unsharp mask after resizing, as sigma[,amount[,threshold]], where sigma is the radius of the blur in pixels, amount (default 1) is the strength, and threshold (default 0) is the minimum difference in percent that is sharpened, e.g. 1,0.8,2")
+	colorMode := flag.String("color", "", "convert the colors of the output image; options are gray (luma-weighted grayscale), sepia, duotone (see -duotone), invert, red, green, blue, and alpha (a single channel as grayscale), and threshold (black and white, see -threshold)")
+	duotone := flag.String("duotone", "black,white", "the colors that the shadows and highlights are mapped to by -color=duotone, separated by a comma, as hex values (e.g. #000080) or SVG color names (e.g. navy)")
+	threshold := flag.Float64("threshold", 50, "the brightness, in percent, at or above which pixels become white with -color=threshold; accepted values are 0-100")
+	padding := flag.Int("padding", 0, "add this many pixels of -paddingColor around the output image, inside -border")
+	paddingColor := flag.String("paddingColor", "transparent", "the color of -padding, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white)")
+	border := flag.Int("border", 0, "draw a border of this many pixels around the output image and its padding")
+	borderColor := flag.String("borderColor", "black", "the color of -border, as a hex value (e.g. #000000) or an SVG color name (e.g. black)")
+	borderGradient := flag.String("borderGradient", "", "if specified, -border fades from -borderColor at the top to this color at the bottom, as a hex value (e.g. #ffffff) or an SVG color name (e.g. white)")
+	radius := flag.Float64("radius", 0, "round the corners of the output image, including its border, with this radius in pixels; the corners become transparent")
+	dropShadow := flag.String("dropShadow", "", "the color of a drop shadow behind the output image, as a hex value (e.g. #00000080) or an SVG color name (e.g. black); the image grows to hold it; if not specified, there is no shadow")
+	dropShadowOffset := flag.String("dropShadowOffset", "4,4", "the offset of -dropShadow, as x,y in pixels")
+	dropShadowBlur := flag.Float64("dropShadowBlur", 4, "the blur radius of -dropShadow in pixels; 0 draws a hard shadow")
+	var ops stringsFlag
+	flag.Var(&ops, "op", "an operation applied after all of the other flags except -flatten and -color, as name:arguments; may be repeated, and the operations are applied in order; options are crop:x,y,w,h, resize:WxH[,fit] (or resize:Wx or resize:xH), rotate:degrees, flip:direction, filter:name[=value] (blur=sigma, boxblur=radius, unsharp=sigma[,amount[,threshold]], sharpen, edge, emboss, or kernel=rows), overlay:layer (as in -layer), flatten[:color], and quantize:n[,dither]; e.g. -op crop:0,0,50%,50% -op resize:400x -op filter:unsharp=1 -op quantize:64")
+	var layers stringsFlag
+	flag.Var(&layers, "layer", "an image drawn over the output image after resizing and before -overlay, as a path followed by optional settings separated by semicolons, e.g. shadow.png;blend=multiply;opacity=60; the settings are blend (as in -overlayBlend), opacity (percent), gravity, offset (x,y in pixels), scale (percent of the image width), mask (the path of an image whose brightness sets the opacity of the layer), and tile (true or false); may be repeated, and layers are drawn in order")
+	overlay := flag.String("overlay", "", "the path of an image, in any supported format, drawn over the output image after resizing, e.g. a logo")
+	overlayGravity := flag.String("overlayGravity", "southeast", "the position of -overlay; options are center, north, northeast, east, southeast, south, southwest, west, and northwest")
+	overlayOffset := flag.String("overlayOffset", "0,0", "moves -overlay away from the edges given by -overlayGravity (or right and down for center), as x,y in pixels")
+	overlayScale := flag.Float64("overlayScale", 0, "the width of -overlay in percent of the width of the output image, keeping its proportions; if 0, its size is kept")
+	overlayOpacity := flag.Float64("overlayOpacity", 100, "the opacity of -overlay in percent; accepted values are 0-100")
+	overlayBlend := flag.String("overlayBlend", "normal", "how the colors of -overlay combine with the image; options are normal, multiply, screen, overlay, darken, lighten, difference, and softlight")
+	overlayTile := flag.Bool("overlayTile", false, "if true, -overlay is repeated over the whole image, starting from its position")
+	text := flag.String("text", "", "text drawn over the output image after resizing, e.g. a caption or a watermark; \\n starts a new line, and long lines are wrapped to the width of -textBox")
+	fontPath := flag.String("font", "goregular", "the font used by -text; the path of a TrueType or OpenType file, or one of the bundled Go fonts: goregular, gobold, goitalic, gobolditalic, gomedium, gomono, gomonobold, and gosmallcaps")
+	textSize := flag.String("textSize", "5%", "the size of -text in pixels, or in percent of the image height, e.g. 24 or 5%")
+	textColor := flag.String("textColor", "white", "the color of -text, as a hex value (e.g. #ffffff80) or an SVG color name (e.g. white)")
+	textStroke := flag.String("textStroke", "", "the color of the outline of -text, as a hex value (e.g. #000000) or an SVG color name (e.g. black); if not specified, there is no outline")
+	textStrokeWidth := flag.Float64("textStrokeWidth", 2, "the width of the outline of -text in pixels")
+	textShadow := flag.String("textShadow", "", "the color of the drop shadow of -text, as a hex value (e.g. #00000080) or an SVG color name (e.g. black); if not specified, there is no shadow")
+	textShadowOffset := flag.String("textShadowOffset", "2,2", "the offset of the shadow of -text, as x,y in pixels")
+	textShadowBlur := flag.Float64("textShadowBlur", 2, "the blur radius of the shadow of -text in pixels; 0 draws a hard shadow")
+	textAlign := flag.String("textAlign", "center", "the alignment of the lines of -text; options are left, center, and right")
+	textBox := flag.String("textBox", "", "the box that -text is wrapped to and placed in, as x,y,w,h in pixels or x%,y%,w%,h% in percent of the image dimensions; if not specified, the whole image, inset by half the font size")
+	textGravity := flag.String("textGravity", "center", "the position of -text inside -textBox; options are center, north, northeast, east, southeast, south, southwest, west, and northwest")
 	recursive := flag.Bool("recursive", false, "if true and -mode=dir, imgconv will parse all files in the target directory, including all subdirectories")
+	force8Bit := flag.Bool("force8Bit", false, "if true, 16-bit images are written with 8 bits per channel, even if the output format supports 16 bits")
+	fixExt := flag.Bool("fixExt", false, "if true, an output file name given by -out is renamed with the extension of the output format if its extension does not match")
 	maxProcs := flag.Uint("maxProcs", 10, "the maximum number of files that can be processed in parallel in dir mode")
-	webpLossy := flag.Bool("webpLossy", false, "if true, lossy compression will be used for webp encoding")
-	webpQuality := flag.Uint("webpQual", 100, "the image quality of output webp files when -webpLossy=true; accepted values are 0-100 (low - high)")
+	codecFlags := DefineCodecFlags(flag.CommandLine) // the options of every registered codec, e.g. -jpegQual
 
 	flag.Parse()
 
@@ -48,47 +114,391 @@ func main() {
 		os.Exit(1)
 	}
 
-	dstFormat := utils.StringToFileType(*toFileType)
-	if dstFormat > webpenc.MAX_ENCODE_TYPE { // defined in webp.go and webp_cgo.go
-		if dstFormat == utils.UNSUPPORTED {
-			log.Fatalln("unsupported output file format")
+	dstFormat := LookupCodec(*toFileType)
+	switch {
+	case dstFormat == nil:
+		log.Fatalf("unsupported output file format; %s are supported\n", EncoderNames())
+	case dstFormat.Disabled != "":
+		log.Fatalln(dstFormat.Disabled)
+	case dstFormat.Encode == nil:
+		log.Fatalf("%s encoding is not supported\n", dstFormat.Name)
+	}
+
+	if *interpolator != "" {
+		if _, err := ParseInterpolator(*interpolator); err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	// the sizing flags are mutually exclusive, except for -height and -width
+	var sizing []string
+	if *height > 0 || *width > 0 {
+		sizing = append(sizing, "-height/-width")
+	}
+	for name, v := range map[string]int{"-scaleToHeight": *scaleToHeight, "-scaleToWidth": *scaleToWidth, "-maxSidePixels": *maxSidePixels, "-minSidePixels": *minSidePixels} {
+		if v > 0 {
+			sizing = append(sizing, name)
+		}
+	}
+	if *fit != "" {
+		if _, err := ParseFitMode(*fit); err != nil {
+			log.Fatalln(err.Error())
+		}
+		sizing = append(sizing, "-fit")
+	}
+	if len(sizing) > 1 {
+		sort.Strings(sizing)
+		log.Fatalf("conflicting resize flags %s; only one may be used at a time\n", strings.Join(sizing, ", "))
+	}
+
+	var rsmplOpts []ResampleOpt
+	if *crop != "" {
+		rect, percent, err := ParseCropRect(*crop)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		rsmplOpts = append(rsmplOpts, WithCropRect(rect, percent))
+	}
+	grav, err := ParseGravity(*gravity)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if *fit != "" || *size != "" {
+		if *fit == "" || *size == "" {
+			log.Fatalln("-fit and -size must be used together")
+		}
+		mode, err := ParseFitMode(*fit)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		w, h, err := ParseSize(*size)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		pad, err := ParseColor(*padColor)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		rsmplOpts = append(rsmplOpts, WithFit(mode, w, h, grav, pad))
+	}
+	if *cropAspect != "" {
+		aspect, err := ParseAspect(*cropAspect)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		rsmplOpts = append(rsmplOpts, WithCropAspect(aspect, grav))
+	}
+
+	switch *resizeMode {
+	case "scale":
+		if *seamMask != "" {
+			log.Fatalln("-seamMask requires -resizeMode=seam")
 		}
-		if webpenc.MAX_ENCODE_TYPE < utils.WEBP {
-			log.Fatalln("webp encoding is not enabled; review the documentation at github.com/cdillond/imgconv for details")
+	case "seam":
+		var mask image.Image
+		if *seamMask != "" {
+			var err error
+			mask, _, err = DecodeLocal(*seamMask)
+			if err != nil {
+				log.Fatalln("could not read seam mask: " + err.Error())
+			}
 		}
-		log.Fatalln("error parsing to file format flag input") // this should not happen
+		rsmplOpts = append(rsmplOpts, WithSeamCarve(true, mask))
+	default:
+		log.Fatalln("invalid resizeMode " + *resizeMode)
 	}
 
-	encCfg := NewEncodeCfg(
-		dstFormat,
-		WithJpegQuality(int(*jpegQuality)),
-		WithGifNumColors(int(*gifNumColors)),
-		WithWebPLossy(*webpLossy),
-		WithWebPQual(*webpQuality),
+	var trOpts []TransformOpt
+	if *flip != "" {
+		o, err := ParseFlip(*flip)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		trOpts = append(trOpts, WithOrientation(o))
+	}
+	if *transpose {
+		trOpts = append(trOpts, WithOrientation(OrientTranspose))
+	}
+	if *transverse {
+		trOpts = append(trOpts, WithOrientation(OrientTransverse))
+	}
+	rotBg, err := ParseColor(*rotateBg)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	trOpts = append(trOpts, WithRotate(*rotate), WithRotateCanvas(rotBg, *rotateExpand), WithTransformInterpolator(*interpolator))
+
+	var trimCfg TrimCfg
+	if *trim != "" {
+		mode, c, err := ParseTrim(*trim)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		trimCfg = NewTrimCfg(mode, c, *trimFuzz/100)
+	}
+
+	adjustCfg := NewAdjustCfg(
+		WithBrightness(*brightness),
+		WithContrast(*contrast),
+		WithGamma(*gamma),
+		WithSaturation(*saturation),
+		WithHue(*hue),
+		WithAutoLevels(*autoLevels),
+		WithAdjustThreads(*threads),
 	)
-	rsmplCfg := NewResampleCfg(
+
+	convOpts := []ConvolveOpt{WithGaussianBlur(*blur), WithBoxBlur(*boxBlur)}
+	if *kernel != "" {
+		k, err := ParseKernel(*kernel)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		convOpts = append(convOpts, WithKernel(k))
+	}
+	if *edge {
+		convOpts = append(convOpts, WithKernel(EdgeKernel))
+	}
+	if *emboss {
+		convOpts = append(convOpts, WithKernel(EmbossKernel))
+	}
+	if *sharpen {
+		convOpts = append(convOpts, WithKernel(SharpenKernel))
+	}
+	if *unsharp != "" {
+		sigma, amount, threshold, err := ParseUnsharp(*unsharp)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		convOpts = append(convOpts, WithUnsharpMask(sigma, amount, threshold))
+	}
+	convolveCfg := NewConvolveCfg(append(convOpts, WithConvolveThreads(*threads))...)
+
+	compOpts := []CompositeOpt{WithCompositeThreads(*threads)}
+	for _, spec := range layers {
+		l, err := ParseLayer(spec)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		compOpts = append(compOpts, WithLayer(l))
+	}
+	compositeCfg := NewCompositeCfg(compOpts...)
+
+	var overlayCfg OverlayCfg
+	if *overlay != "" {
+		over, _, err := DecodeLocal(*overlay)
+		if err != nil {
+			log.Fatalln("could not read overlay: " + err.Error())
+		}
+		grav, err := ParseGravity(*overlayGravity)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		offset, err := ParseOffset(*overlayOffset)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		blend, err := ParseBlendMode(*overlayBlend)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		overlayCfg = NewOverlayCfg(over,
+			WithOverlayPosition(grav, offset),
+			WithOverlayScale(*overlayScale/100),
+			WithOverlayOpacity(*overlayOpacity/100),
+			WithOverlayBlend(blend),
+			WithOverlayTile(*overlayTile),
+			WithOverlayThreads(*threads),
+		)
+	}
+
+	var textCfg TextCfg
+	if *text != "" {
+		f, err := LoadFont(*fontPath)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		relative := strings.HasSuffix(*textSize, "%")
+		size, err := strconv.ParseFloat(strings.TrimSuffix(*textSize, "%"), 64)
+		if err != nil || size <= 0 {
+			log.Fatalf("invalid textSize %q; expected a positive number of pixels or a percentage\n", *textSize)
+		}
+		if relative {
+			size /= 100
+		}
+		fill, err := ParseColor(*textColor)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		align, err := ParseTextAlign(*textAlign)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		grav, err := ParseGravity(*textGravity)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		var box [4]float64
+		var percent bool
+		if *textBox != "" {
+			box, percent, err = ParseCropRect(*textBox)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+		}
+		txtOpts := []TextOpt{
+			WithFont(f),
+			WithTextSize(size, relative),
+			WithTextColor(fill),
+			WithTextAlign(align),
+			WithTextBox(box, percent, grav),
+			WithTextThreads(*threads),
+		}
+		if *textStroke != "" {
+			c, err := ParseColor(*textStroke)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			txtOpts = append(txtOpts, WithTextStroke(c, *textStrokeWidth))
+		}
+		if *textShadow != "" {
+			c, err := ParseColor(*textShadow)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			offset, err := ParseOffset(*textShadowOffset)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			txtOpts = append(txtOpts, WithTextShadow(c, offset, *textShadowBlur))
+		}
+		textCfg = NewTextCfg(strings.ReplaceAll(*text, `\n`, "\n"), txtOpts...)
+		if err = textCfg.Validate(); err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	padFill, err := ParseColor(*paddingColor)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	bColor, err := ParseColor(*borderColor)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	frameOpts := []FrameOpt{
+		WithPadding(*padding, padFill),
+		WithRoundedCorners(*radius),
+		WithFrameThreads(*threads),
+	}
+	if *borderGradient != "" {
+		to, err := ParseColor(*borderGradient)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		frameOpts = append(frameOpts, WithBorder(*border, bColor, to))
+	} else {
+		frameOpts = append(frameOpts, WithBorder(*border, bColor, nil))
+	}
+	if *dropShadow != "" {
+		c, err := ParseColor(*dropShadow)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		offset, err := ParseOffset(*dropShadowOffset)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		frameOpts = append(frameOpts, WithDropShadow(c, offset, *dropShadowBlur))
+	}
+	frameCfg := NewFrameCfg(frameOpts...)
+
+	var flattenCfg FlattenCfg
+	if *flatten || !dstFormat.Alpha {
+		flattenCfg, err = NewFlattenCfg(*background)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	}
+
+	var colorCfg ColorCfg
+	if *colorMode != "" {
+		cm, err := ParseColorMode(*colorMode)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		dark, light, err := ParseDuotone(*duotone)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		colorCfg = NewColorCfg(WithColorMode(cm), WithDuotone(dark, light), WithThreshold(*threshold/100), WithColorThreads(*threads))
+	}
+
+	encOpts, ignored := codecFlags.EncodeOpts(dstFormat)
+	for _, name := range ignored {
+		log.Printf("-%s does not apply to %s output and will be ignored\n", name, dstFormat.Name)
+	}
+	encCfg := NewEncodeCfg(dstFormat, append(encOpts, WithForce8Bit(*force8Bit))...)
+	// the resampling options shared by the resizing flags and -op crop and resize
+	sharedRsmplOpts := []ResampleOpt{
 		WithAllowUpsize(*allowUpsize),
-		WithRescale(*height, *width, *scaleToHeight, *scaleToWidth, *maxSidePixels, *minSidePixels),
+		WithHighBitDepth(dstFormat.HighBitDepth && !*force8Bit),
 		WithInterpolator(*interpolator),
-	)
+		WithLinearLight(*linear),
+		WithPyramidRatio(*pyramidRatio),
+		WithThreads(*threads),
+	}
+	rsmplCfg := NewResampleCfg(append(append(rsmplOpts, sharedRsmplOpts...),
+		WithRescale(*height, *width, *scaleToHeight, *scaleToWidth, *maxSidePixels, *minSidePixels),
+		WithCropAfterScale(*cropAfterScale),
+	)...)
+
+	var pipeline Pipeline
+	opDefaults := OpDefaults{
+		Resample:   sharedRsmplOpts,
+		Transform:  []TransformOpt{WithRotateCanvas(rotBg, *rotateExpand), WithTransformInterpolator(*interpolator)},
+		Background: *background,
+		Threads:    *threads,
+	}
+	for _, spec := range ops {
+		step, err := ParseOp(spec, opDefaults)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		pipeline = append(pipeline, step)
+	}
+
+	procCfg := ProcessCfg{
+		Adjust:    adjustCfg,
+		Transform: NewTransformCfg(trOpts...),
+		Trim:      trimCfg,
+		Resample:  rsmplCfg,
+		Convolve:  convolveCfg,
+		Composite: compositeCfg,
+		Overlay:   overlayCfg,
+		Text:      textCfg,
+		Frame:     frameCfg,
+		Ops:       pipeline,
+		Flatten:   flattenCfg,
+		Color:     colorCfg,
+	}
 
-	var err error
 	var img image.Image
+	var srcFormat *Codec
 	switch *mode {
 	case "dir":
-		err = ProcessDir(*srcUrl, *dstDir, *maxProcs, *recursive, encCfg, rsmplCfg)
+		err = ProcessDir(*srcUrl, *dstDir, *maxProcs, *recursive, encCfg, procCfg)
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
 		return
 	case "local":
-		img, _, err = DecodeLocal(*srcUrl)
+		img, srcFormat, err = DecodeLocal(*srcUrl)
 	case "remote":
 		img, _, err = DecodeRemote(*srcUrl)
 		if err == ErrDataURL {
 			err = nil
 			if *dstFileName == "" {
-				tmp := fmt.Sprintf("%s.%s", uuid.NewString(), utils.FileTypeToString(dstFormat))
+				tmp := fmt.Sprintf("%s.%s", uuid.NewString(), dstFormat.Ext())
 				dstFileName = &tmp
 			}
 		}
@@ -99,34 +509,42 @@ func main() {
 		log.Fatalln(err.Error())
 	}
 
-	if rsmplCfg.IsUsed {
-		img = Rescale(img, rsmplCfg)
-	}
 	dstPath, err := GetDstFilePath(*dstFileName, *dstDir, *srcUrl, *mode == "remote", dstFormat)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+	if *dstFileName != "" && *fixExt {
+		dstPath = FixExt(dstPath, dstFormat)
+	}
 
 	// check if file path already exists, and adjust name to avoid collisions
 	// specifying an output file name via --out overrides this behavior
 	if _, err = os.Stat(dstPath); err == nil && *dstFileName == "" {
-		var version int
-		fdir := filepath.Dir(dstPath)
-		fNameExt := filepath.Base(dstPath)
-		fNameExtSlice := strings.Split(fNameExt, ".")
-		if len(fNameExtSlice) <= 1 {
-			// output file names are required to have extensions and should not otherwise include periods
-			log.Fatalln("invalid output file path " + dstPath)
-		}
-		for ; err == nil; version++ {
-			dstNameVersionExt := fNameExtSlice[0] + "_v" + strconv.Itoa(version+1) + "." + fNameExtSlice[1]
-			dstPath = filepath.Join(fdir, dstNameVersionExt)
+		origDstPath := dstPath
+		for version := 1; err == nil; version++ {
+			dstPath = VersionedPath(origDstPath, version)
 			_, err = os.Stat(dstPath)
 		}
 	}
 
-	err = SaveFile(img, dstPath, encCfg)
+	var srcPath string
+	if *mode == "local" {
+		srcPath = *srcUrl
+	}
+	err = SaveImage(img, srcPath, srcFormat, dstPath, encCfg, procCfg)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
 }
+
+// stringsFlag collects the values of a flag that may be repeated
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"image"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/cdillond/imgconv/pkg/utils"
+	"github.com/cdillond/imgconv/pkg/jpegtran"
+	"github.com/cdillond/imgconv/pkg/jxl"
 
 	"github.com/google/uuid"
 )
@@ -26,7 +30,119 @@ func SaveFile(img image.Image, dstPath string, encCfg EncodeCfg) error {
 	return f.Close()
 }
 
-func GetDstFilePath(dstFileName, dstDir, srcUrl string, isRemote bool, fileType utils.FileType) (string, error) {
+// TranscodeJpegFile losslessly recompresses the jpeg file at srcPath to a jxl file at dstPath,
+// without decoding it to pixels first
+func TranscodeJpegFile(srcPath, dstPath string, encCfg EncodeCfg) error {
+	b, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = jxl.TranscodeJPEG(&buf, b, jxl.JXLOptions{Effort: encCfg.JxlEffort}); err != nil {
+		return err
+	}
+	return replaceFile(dstPath, buf.Bytes())
+}
+
+// replaceFile writes data to a temporary file in the directory of path and renames it to path, so that path,
+// which may be the source file, is left untouched if anything fails. An existing file keeps its permissions.
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name()) // can ignore the error returned here
+	}
+	return err
+}
+
+// CanTranscodeJpeg reports whether a source file can be losslessly recompressed instead of re-encoded
+func CanTranscodeJpeg(srcFormat *Codec, encCfg EncodeCfg, procCfg ProcessCfg) bool {
+	return srcFormat != nil && srcFormat.Name == "jpeg" &&
+		encCfg.Codec != nil && encCfg.Codec.Name == "jxl" &&
+		encCfg.JxlTranscodeJpeg && !procCfg.IsUsed()
+}
+
+// TransformJpegFile rotates, flips, and/or crops the jpeg file at srcPath according to opts and writes the result
+// to dstPath, without re-encoding it. The errors of jpegtran.Apply are returned unchanged, so that callers can
+// fall back to re-encoding (see isJpegtranError).
+func TransformJpegFile(srcPath, dstPath string, opts jpegtran.Options) error {
+	b, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = jpegtran.Apply(&buf, bytes.NewReader(b), opts); err != nil {
+		return err
+	}
+	return replaceFile(dstPath, buf.Bytes())
+}
+
+// isJpegtranError reports whether err means that jpegtran could not transform a file, rather than an I/O error
+func isJpegtranError(err error) bool {
+	for _, e := range []error{jpegtran.ErrProgressive, jpegtran.ErrUnsupported, jpegtran.ErrCorrupt, jpegtran.ErrImperfect} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// LosslessJpegOpts reports whether the operations in procCfg can be applied to a jpeg source file without
+// re-encoding it, and returns the equivalent options; size is the size of the source image. The options are
+// Perfect, so transforms and crops that are not aligned to whole MCUs fail with jpegtran.ErrImperfect.
+func LosslessJpegOpts(srcFormat *Codec, size image.Point, encCfg EncodeCfg, procCfg ProcessCfg) (jpegtran.Options, bool) {
+	if srcFormat == nil || srcFormat.Name != "jpeg" || encCfg.Codec == nil || encCfg.Codec.Name != "jpeg" ||
+		!encCfg.JpegLossless || !procCfg.IsUsed() {
+		return jpegtran.Options{}, false
+	}
+	if !procCfg.reorientsOnly() {
+		return jpegtran.Options{}, false
+	}
+	tr, rs := procCfg.Transform, procCfg.Resample
+	opts := jpegtran.Options{Transform: jpegtran.Transform(tr.Orientation), Perfect: true}
+	if rs.Crop.IsUsed {
+		if tr.Orientation.Swaps() {
+			size.X, size.Y = size.Y, size.X
+		}
+		opts.Crop = CropRect(image.Rectangle{Max: size}, rs.Crop)
+	}
+	return opts, true
+}
+
+// SaveImage processes img according to procCfg and writes it to dstPath. If srcPath is the local file img was
+// decoded from, jpeg sources are losslessly recompressed or transformed instead of re-encoded when possible.
+func SaveImage(img image.Image, srcPath string, srcFormat *Codec, dstPath string, encCfg EncodeCfg, procCfg ProcessCfg) error {
+	if srcPath != "" {
+		if CanTranscodeJpeg(srcFormat, encCfg, procCfg) {
+			return TranscodeJpegFile(srcPath, dstPath, encCfg)
+		}
+		if opts, ok := LosslessJpegOpts(srcFormat, img.Bounds().Size(), encCfg, procCfg); ok {
+			// files that jpegtran cannot parse, and transforms that would not be exact, are re-encoded instead
+			if err := TransformJpegFile(srcPath, dstPath, opts); !isJpegtranError(err) {
+				return err
+			}
+		}
+	}
+	return SaveFile(Process(img, procCfg), dstPath, encCfg)
+}
+
+func GetDstFilePath(dstFileName, dstDir, srcUrl string, isRemote bool, codec *Codec) (string, error) {
 	var dstName string
 	if dstFileName != "" {
 		// TO DO validate dstFileName to avoid possible issues with hidden files, files without extensions, and file names that include multiple periods
@@ -55,13 +171,16 @@ func GetDstFilePath(dstFileName, dstDir, srcUrl string, isRemote bool, fileType
 	} else {
 		srcFileNameExt = filepath.Base(srcUrl)
 	}
-	// does not allow periods in file names
-	srcNameExtSlice := strings.Split(srcFileNameExt, ".")
-	if len(srcNameExtSlice) < 1 {
+	// only a recognized image extension is stripped, so other periods are kept as part of the name
+	srcFileName := srcFileNameExt
+	if ExtFormat(srcFileNameExt) != nil {
+		srcFileName = strings.TrimSuffix(srcFileNameExt, filepath.Ext(srcFileNameExt))
+	}
+	if srcFileName == "" || srcFileName == "." || srcFileName == "/" {
 		// assign random name
-		dstFileNameExt = uuid.NewString() + "." + utils.FileTypeToString(fileType)
+		dstFileNameExt = uuid.NewString() + "." + codec.Ext()
 	} else {
-		dstFileNameExt = srcNameExtSlice[0] + "." + utils.FileTypeToString(fileType)
+		dstFileNameExt = srcFileName + "." + codec.Ext()
 	}
 
 	if dstDir != "" {
@@ -74,3 +193,9 @@ func GetDstFilePath(dstFileName, dstDir, srcUrl string, isRemote bool, fileType
 	}
 
 }
+
+// VersionedPath inserts "_v" and a version number between the name and extension of path
+func VersionedPath(path string, version int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_v" + strconv.Itoa(version) + ext
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+type OverlayCfg struct {
+	IsUsed  bool
+	Image   image.Image
+	Gravity Gravity     // where the overlay is placed; Smart is treated as Center
+	Offset  image.Point // moves the overlay away from the edges pinned by Gravity, or right and down for Center
+	Scale   float64     // the width of the overlay as a fraction of the width of the image; 0 keeps its size
+	Opacity float64     // 0-1
+	Blend   BlendMode
+	Tile    bool // repeat the overlay over the whole image, starting from its position
+	Threads int
+}
+
+func NewOverlayCfg(overlay image.Image, opts ...OverlayOpt) OverlayCfg {
+	cfg := OverlayCfg{
+		IsUsed:  overlay != nil,
+		Image:   overlay,
+		Gravity: SouthEast,
+		Opacity: 1,
+		Blend:   BlendNormal,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type OverlayOpt func(*OverlayCfg)
+
+func WithOverlayPosition(gravity Gravity, offset image.Point) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Gravity, o.Offset = gravity, offset
+	}
+}
+
+// the width of the overlay as a fraction of the width of the image, keeping its proportions; values <= 0 keep its size
+func WithOverlayScale(scale float64) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Scale = max(scale, 0)
+	}
+}
+
+// opacity is clamped to 0-1
+func WithOverlayOpacity(opacity float64) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Opacity = min(max(opacity, 0), 1)
+	}
+}
+
+func WithOverlayBlend(mode BlendMode) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Blend = mode
+	}
+}
+
+func WithOverlayTile(tile bool) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Tile = tile
+	}
+}
+
+// the number of goroutines used to scale and draw the overlay; values < 1 use all CPUs
+func WithOverlayThreads(n int) func(*OverlayCfg) {
+	return func(o *OverlayCfg) {
+		o.Threads = n
+	}
+}
+
+// ParseOffset parses an x,y offset in pixels, e.g. "10,-20".
+func ParseOffset(s string) (image.Point, error) {
+	x, y, ok := strings.Cut(s, ",")
+	if ok {
+		px, errX := strconv.Atoi(strings.TrimSpace(x))
+		py, errY := strconv.Atoi(strings.TrimSpace(y))
+		if errX == nil && errY == nil {
+			return image.Pt(px, py), nil
+		}
+	}
+	return image.Point{}, fmt.Errorf("invalid offset %q; expected x,y in pixels, e.g. 10,10", s)
+}
+
+// Overlay returns a copy of img with cfg.Image drawn over it, with its origin at (0, 0).
+func Overlay(img image.Image, cfg OverlayCfg) image.Image {
+	if !cfg.IsUsed || cfg.Image == nil || cfg.Opacity <= 0 {
+		return img
+	}
+	return CompositeLayers(img, NewCompositeCfg(WithLayer(Layer{
+		Image:   cfg.Image,
+		Gravity: cfg.Gravity,
+		Offset:  cfg.Offset,
+		Scale:   cfg.Scale,
+		Opacity: cfg.Opacity,
+		Blend:   cfg.Blend,
+		Tile:    cfg.Tile,
+	}), WithCompositeThreads(cfg.Threads)))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Step is one operation of a Pipeline. Apply returns the processed image, or img itself if the step
+// does not change it; it must not modify img.
+type Step interface {
+	Apply(img image.Image) image.Image
+}
+
+// Pipeline applies its steps in order. A Pipeline is itself a Step.
+type Pipeline []Step
+
+func (p Pipeline) Apply(img image.Image) image.Image {
+	for _, s := range p {
+		img = s.Apply(img)
+	}
+	return img
+}
+
+// the configurations of the built-in operations are steps
+
+func (cfg AdjustCfg) Apply(img image.Image) image.Image    { return Adjust(img, cfg) }
+func (cfg TransformCfg) Apply(img image.Image) image.Image { return Transform(img, cfg) }
+func (cfg TrimCfg) Apply(img image.Image) image.Image      { return Trim(img, cfg) }
+func (cfg ConvolveCfg) Apply(img image.Image) image.Image  { return Convolve(img, cfg) }
+func (cfg CompositeCfg) Apply(img image.Image) image.Image { return CompositeLayers(img, cfg) }
+func (cfg OverlayCfg) Apply(img image.Image) image.Image   { return Overlay(img, cfg) }
+func (cfg TextCfg) Apply(img image.Image) image.Image      { return DrawText(img, cfg) }
+func (cfg FrameCfg) Apply(img image.Image) image.Image     { return Frame(img, cfg) }
+func (cfg FlattenCfg) Apply(img image.Image) image.Image   { return Flatten(img, cfg) }
+func (cfg ColorCfg) Apply(img image.Image) image.Image     { return ConvertColor(img, cfg) }
+func (cfg QuantizeCfg) Apply(img image.Image) image.Image  { return Quantize(img, cfg) }
+
+func (cfg ResampleCfg) Apply(img image.Image) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	return Rescale(img, cfg)
+}
+
+// OpDefaults holds the settings that ParseOp applies to every step it parses, e.g. from command line flags.
+type OpDefaults struct {
+	Resample   []ResampleOpt  // added to crop and resize steps, e.g. the interpolator
+	Transform  []TransformOpt // added to rotate and flip steps, e.g. the background of rotations
+	Background string         // the color used by flatten steps that do not name one
+	Threads    int
+}
+
+var opNames = []string{"crop", "resize", "rotate", "flip", "filter", "overlay", "flatten", "quantize"}
+
+// ParseOp parses a step given as name:arguments. The steps are
+//
+//	crop:x,y,w,h           crop to a rectangle in pixels, or x%,y%,w%,h% in percent (see ParseCropRect)
+//	resize:WxH[,fit]       resize to W x H, stretching the image unless a fit mode is given (see ParseFitMode);
+//	                       resize:Wx or resize:xH keep the proportions of the image
+//	rotate:degrees         rotate clockwise
+//	flip:direction         mirror horizontally, vertically, or both (see ParseFlip)
+//	filter:name[=value]    a convolution filter: blur=sigma, boxblur=radius, unsharp=sigma[,amount[,threshold]],
+//	                       sharpen, edge, emboss, or kernel=rows (see ParseUnsharp and ParseKernel)
+//	overlay:layer          draw an image over this one (see ParseLayer)
+//	flatten[:color]        flatten transparent images onto a color, or checkerboard (see NewFlattenCfg)
+//	quantize:n[,dither]    reduce the image to n colors with MedianCut, optionally with dithering
+func ParseOp(spec string, defaults OpDefaults) (Step, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	wrap := func(err error) error {
+		return fmt.Errorf("invalid op %q: %w", spec, err)
+	}
+	switch name {
+	case "crop":
+		rect, percent, err := ParseCropRect(arg)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		return NewResampleCfg(append([]ResampleOpt{WithCropRect(rect, percent)}, defaults.Resample...)...), nil
+
+	case "resize":
+		size, fit, _ := strings.Cut(arg, ",")
+		ws, hs, found := strings.Cut(strings.ToLower(size), "x")
+		w, errW := strconv.Atoi(ws)
+		h, errH := strconv.Atoi(hs)
+		var opt ResampleOpt
+		switch {
+		case !found:
+		case ws == "" && errH == nil && h > 0 && fit == "":
+			opt = WithRescale(-1, -1, h, -1, -1, -1)
+		case hs == "" && errW == nil && w > 0 && fit == "":
+			opt = WithRescale(-1, -1, -1, w, -1, -1)
+		case errW == nil && errH == nil && w > 0 && h > 0:
+			if fit == "" {
+				opt = WithRescale(h, w, -1, -1, -1, -1)
+				break
+			}
+			mode, err := ParseFitMode(fit)
+			if err != nil {
+				return nil, wrap(err)
+			}
+			opt = WithFit(mode, w, h, Center, nil)
+		}
+		if opt == nil {
+			return nil, wrap(fmt.Errorf("expected WxH[,fit], Wx, or xH, e.g. 800x600,cover"))
+		}
+		return NewResampleCfg(append([]ResampleOpt{opt}, defaults.Resample...)...), nil
+
+	case "rotate":
+		deg, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, wrap(fmt.Errorf("expected an angle in degrees"))
+		}
+		return NewTransformCfg(append([]TransformOpt{WithRotate(deg)}, defaults.Transform...)...), nil
+
+	case "flip":
+		o, err := ParseFlip(arg)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		return NewTransformCfg(append([]TransformOpt{WithOrientation(o)}, defaults.Transform...)...), nil
+
+	case "filter":
+		filter, value, _ := strings.Cut(arg, "=")
+		var opt ConvolveOpt
+		var err error
+		switch strings.ToLower(filter) {
+		case "blur":
+			if sigma, e := strconv.ParseFloat(value, 64); e == nil && sigma > 0 {
+				opt = WithGaussianBlur(sigma)
+			}
+		case "boxblur":
+			if r, e := strconv.Atoi(value); e == nil && r > 0 {
+				opt = WithBoxBlur(r)
+			}
+		case "unsharp":
+			var sigma, amount, threshold float64
+			if sigma, amount, threshold, err = ParseUnsharp(value); err == nil {
+				opt = WithUnsharpMask(sigma, amount, threshold)
+			}
+		case "sharpen":
+			opt = WithKernel(SharpenKernel)
+		case "edge":
+			opt = WithKernel(EdgeKernel)
+		case "emboss":
+			opt = WithKernel(EmbossKernel)
+		case "kernel":
+			var k [][]float64
+			if k, err = ParseKernel(value); err == nil {
+				opt = WithKernel(k)
+			}
+		default:
+			err = fmt.Errorf("unknown filter %q; options are blur, boxblur, unsharp, sharpen, edge, emboss, and kernel", filter)
+		}
+		if err != nil {
+			return nil, wrap(err)
+		}
+		if opt == nil {
+			return nil, wrap(fmt.Errorf("expected a positive value, e.g. blur=2 or boxblur=3"))
+		}
+		return NewConvolveCfg(opt, WithConvolveThreads(defaults.Threads)), nil
+
+	case "overlay":
+		l, err := ParseLayer(arg)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		return NewCompositeCfg(WithLayer(l), WithCompositeThreads(defaults.Threads)), nil
+
+	case "flatten":
+		bg := arg
+		if bg == "" {
+			bg = defaults.Background
+		}
+		cfg, err := NewFlattenCfg(bg)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		return cfg, nil
+
+	case "quantize":
+		ns, dither, _ := strings.Cut(arg, ",")
+		n, err := strconv.Atoi(ns)
+		if err != nil || n < 2 || n > 256 || (dither != "" && dither != "dither") {
+			return nil, wrap(fmt.Errorf("expected a number of colors from 2 to 256, optionally followed by ,dither"))
+		}
+		return NewQuantizeCfg(WithQuantize(n), WithDither(dither != "")), nil
+	}
+	return nil, fmt.Errorf("unknown op %q; options are %s", name, strings.Join(opNames, ", "))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOp(t *testing.T) {
+	defaults := OpDefaults{
+		Resample:   []ResampleOpt{WithThreads(3)},
+		Transform:  []TransformOpt{WithRotateCanvas(color.Black, false)},
+		Background: "white",
+		Threads:    3,
+	}
+	flatten := func(bg string) Step {
+		cfg, err := NewFlattenCfg(bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	for _, tc := range []struct {
+		spec string
+		want Step
+	}{
+		{"crop:10,20,30,40", NewResampleCfg(WithCropRect([4]float64{10, 20, 30, 40}, false), WithThreads(3))},
+		{"crop:0%,0%,50%,50%", NewResampleCfg(WithCropRect([4]float64{0, 0, 50, 50}, true), WithThreads(3))},
+		{"resize:800x600", NewResampleCfg(WithRescale(600, 800, -1, -1, -1, -1), WithThreads(3))},
+		{"resize:800x", NewResampleCfg(WithRescale(-1, -1, -1, 800, -1, -1), WithThreads(3))},
+		{"resize:x600", NewResampleCfg(WithRescale(-1, -1, 600, -1, -1, -1), WithThreads(3))},
+		{"resize:800x600,cover", NewResampleCfg(WithFit(FitCover, 800, 600, Center, nil), WithThreads(3))},
+		{"rotate:90", NewTransformCfg(WithRotate(90), WithRotateCanvas(color.Black, false))},
+		{"rotate:-45.5", NewTransformCfg(WithRotate(-45.5), WithRotateCanvas(color.Black, false))},
+		{"flip:h", NewTransformCfg(WithOrientation(OrientFlipH), WithRotateCanvas(color.Black, false))},
+		{"flip:both", NewTransformCfg(WithOrientation(OrientRotate180), WithRotateCanvas(color.Black, false))},
+		{"filter:blur=2", NewConvolveCfg(WithGaussianBlur(2), WithConvolveThreads(3))},
+		{"filter:boxblur=3", NewConvolveCfg(WithBoxBlur(3), WithConvolveThreads(3))},
+		{"filter:unsharp=1,1.5,2", NewConvolveCfg(WithUnsharpMask(1, 1.5, 0.02), WithConvolveThreads(3))},
+		{"filter:sharpen", NewConvolveCfg(WithKernel(SharpenKernel), WithConvolveThreads(3))},
+		{"filter:kernel=0,1,0;1,4,1;0,1,0", NewConvolveCfg(WithKernel([][]float64{{0, 0.125, 0}, {0.125, 0.5, 0.125}, {0, 0.125, 0}}), WithConvolveThreads(3))},
+		{"flatten", flatten("white")},
+		{"FLATTEN:red", flatten("red")},
+		{"flatten:checkerboard", flatten("checkerboard")},
+		{"quantize:64", NewQuantizeCfg(WithQuantize(64))},
+		{"quantize:2,dither", NewQuantizeCfg(WithQuantize(2), WithDither(true))},
+	} {
+		got, err := ParseOp(tc.spec, defaults)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestParseOpErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"blur:2",
+		"crop:1,2,3",
+		"resize:800",
+		"resize:0x600",
+		"resize:x600,cover",
+		"resize:800x600,stretch",
+		"rotate:right",
+		"flip:sideways",
+		"filter:blur",
+		"filter:blur=-1",
+		"filter:boxblur=0",
+		"filter:unsharp=",
+		"filter:kernel=1,1;1,1",
+		"filter:median",
+		"overlay:" + filepath.Join(t.TempDir(), "missing.png"),
+		"flatten:notacolor",
+		"quantize:1",
+		"quantize:300",
+		"quantize:16,fast",
+	} {
+		if step, err := ParseOp(spec, OpDefaults{Background: "white"}); err == nil {
+			t.Errorf("%q: got %+v, want an error", spec, step)
+		}
+	}
+}
+
+func TestParseOpOverlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layer.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	step, err := ParseOp("overlay:"+path+";blend=multiply;opacity=50", OpDefaults{Threads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, ok := step.(CompositeCfg)
+	if !ok || len(cfg.Layers) != 1 || cfg.Threads != 2 {
+		t.Fatalf("got %+v, want a CompositeCfg with one layer", step)
+	}
+	if l := cfg.Layers[0]; l.Blend != BlendMultiply || l.Opacity != 0.5 || l.Image.Bounds().Dx() != 4 {
+		t.Errorf("got layer %+v", l)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	flatten, err := NewFlattenCfg("white")
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := NewQuantizeCfg(WithQuantize(16))
+	for _, tc := range []struct {
+		mode ColorMode
+		tail []Step // the steps after the fixed ones
+	}{
+		{ColorNone, Pipeline{op, flatten, NewColorCfg()}},
+		{ColorGray, Pipeline{op, flatten, NewColorCfg(WithColorMode(ColorGray))}},
+		{ColorRed, Pipeline{op, flatten, NewColorCfg(WithColorMode(ColorRed))}},
+		// flattening would discard the alpha channel
+		{ColorAlpha, Pipeline{op, NewColorCfg(WithColorMode(ColorAlpha)), flatten}},
+	} {
+		cfg := ProcessCfg{Ops: Pipeline{op}, Flatten: flatten, Color: NewColorCfg(WithColorMode(tc.mode))}
+		steps := cfg.Steps()
+		if len(steps) < len(tc.tail) || !reflect.DeepEqual(steps[len(steps)-len(tc.tail):], Pipeline(tc.tail)) {
+			t.Errorf("mode %d: got steps %+v", tc.mode, steps)
+		}
+		// the flag steps come first, in a fixed order
+		want := []Step{cfg.Adjust, cfg.Transform, cfg.Trim, cfg.Resample, cfg.Convolve, cfg.Composite,
+			cfg.Overlay, cfg.Text, cfg.Frame}
+		if len(steps) != len(want)+len(tc.tail) {
+			t.Fatalf("mode %d: got %d steps, want %d", tc.mode, len(steps), len(want)+len(tc.tail))
+		}
+		for i, s := range steps[:len(want)] {
+			if reflect.TypeOf(s) != reflect.TypeOf(want[i]) {
+				t.Errorf("mode %d: step %d is %T, want %T", tc.mode, i, s, want[i])
+			}
+		}
+	}
+}
+
+// the alpha channel of a transparent image survives flattening with -color=alpha
+func TestProcessAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 0x40})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 0xff})
+	flatten, err := NewFlattenCfg("white")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Process(img, ProcessCfg{Flatten: flatten, Color: NewColorCfg(WithColorMode(ColorAlpha))})
+	for x, want := range []uint8{0x40, 0xff} {
+		if got := color.GrayModel.Convert(out.At(x, 0)).(color.Gray).Y; got != want {
+			t.Errorf("pixel %d: got %#x, want %#x", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !cgo || !avifenc
+
+package avifenc
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+const ENCODE_ENABLED = false
+
+func EncodeAVIF(w io.Writer, img image.Image, opt AVIFOptions) error {
+	// this code should be unreachable
+	return errors.New("avif encoding is not enabled; review docs at github.com/cdillond/imgconv for details")
+}
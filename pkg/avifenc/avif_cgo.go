@@ -0,0 +1,135 @@
+//go:build cgo && avifenc
+
+package avifenc
+
+/*
+	#cgo LDFLAGS: -lavif
+	#include <avif/avif.h>
+	#include <stdlib.h>
+
+avifResult encodeRGBA(const uint8_t* rgba, int width, int height, int stride, int has_alpha, int quality, int alpha_quality, int speed, avifPixelFormat format, avifCodecChoice codec, uint8_t** out, size_t* size) {
+	avifResult res;
+	avifRGBImage rgb;
+	avifRWData output = AVIF_DATA_EMPTY;
+
+	avifImage* img = avifImageCreate(width, height, 8, format);
+	if (img == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+
+	avifRGBImageSetDefaults(&rgb, img);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.ignoreAlpha = has_alpha ? AVIF_FALSE : AVIF_TRUE;
+	rgb.pixels = (uint8_t*)rgba;
+	rgb.rowBytes = stride;
+
+	res = avifImageRGBToYUV(img, &rgb);
+	if (res != AVIF_RESULT_OK) {
+		avifImageDestroy(img);
+		return res;
+	}
+
+	avifEncoder* enc = avifEncoderCreate();
+	if (enc == NULL) {
+		avifImageDestroy(img);
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+	enc->codecChoice = codec;
+	enc->quality = quality;
+	enc->qualityAlpha = alpha_quality;
+	enc->speed = speed;
+
+	res = avifEncoderWrite(enc, img, &output);
+	avifEncoderDestroy(enc);
+	avifImageDestroy(img);
+	if (res != AVIF_RESULT_OK) {
+		avifRWDataFree(&output);
+		return res;
+	}
+	*out = output.data;
+	*size = output.size;
+	return AVIF_RESULT_OK;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+const ENCODE_ENABLED = true
+
+func EncodeAVIF(w io.Writer, img image.Image, opt AVIFOptions) error {
+	// libavif does its own RGB -> YUV conversion, so everything gets handed over as NRGBA
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	// guard against a possible panic if len(nrgba.Pix) < 1
+	if len(nrgba.Pix) < 1 {
+		return errors.New("error encoding avif file; could not convert source image to nrgba")
+	}
+
+	var format C.avifPixelFormat
+	switch opt.Subsample {
+	case "444":
+		format = C.AVIF_PIXEL_FORMAT_YUV444
+	case "422":
+		format = C.AVIF_PIXEL_FORMAT_YUV422
+	case "400":
+		format = C.AVIF_PIXEL_FORMAT_YUV400
+	default:
+		format = C.AVIF_PIXEL_FORMAT_YUV420
+	}
+
+	var codec C.avifCodecChoice
+	switch opt.Codec {
+	case "aom":
+		codec = C.AVIF_CODEC_CHOICE_AOM
+	case "rav1e":
+		codec = C.AVIF_CODEC_CHOICE_RAV1E
+	case "svt":
+		codec = C.AVIF_CODEC_CHOICE_SVT
+	default:
+		codec = C.AVIF_CODEC_CHOICE_AUTO
+	}
+
+	speed := C.int(opt.Speed)
+	if opt.Speed < 0 {
+		speed = C.AVIF_SPEED_DEFAULT
+	}
+	var hasAlpha C.int
+	if !nrgba.Opaque() {
+		hasAlpha = 1
+	}
+
+	var out *C.uint8_t
+	var size C.size_t
+	res := C.encodeRGBA((*C.uint8_t)(&nrgba.Pix[0]),
+		C.int(nrgba.Rect.Dx()),
+		C.int(nrgba.Rect.Dy()),
+		C.int(nrgba.Stride),
+		hasAlpha,
+		C.int(opt.Quality),
+		C.int(opt.AlphaQuality),
+		speed,
+		format,
+		codec,
+		&out,
+		&size)
+	if res != C.AVIF_RESULT_OK {
+		return fmt.Errorf("error encoding avif file: %s", C.GoString(C.avifResultToString(res)))
+	}
+	defer C.avifFree(unsafe.Pointer(out)) // DO NOT FORGET
+
+	b := C.GoBytes(unsafe.Pointer(out), C.int(size))
+	_, err := w.Write(b)
+	return err
+}
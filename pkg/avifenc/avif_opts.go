@@ -0,0 +1,9 @@
+package avifenc
+
+type AVIFOptions struct {
+	Quality      uint   // 0-100 (low - high)
+	AlphaQuality uint   // 0-100 (low - high)
+	Speed        int    // 0-10 (slow - fast); a negative value selects the codec default
+	Subsample    string // 444, 422, 420, or 400
+	Codec        string // aom, rav1e, svt, or empty to let libavif choose
+}
@@ -0,0 +1,432 @@
+package jpegtran
+
+import "encoding/binary"
+
+// block holds the 64 quantized DCT coefficients of an 8x8 block in natural (row-major) order.
+type block [64]int16
+
+// zigzag maps the position of a coefficient in the entropy coded data to its natural order index
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type quantTable struct {
+	precision byte       // 0 for 8-bit values, 1 for 16-bit values
+	q         [64]uint16 // natural order
+}
+
+type component struct {
+	id     byte
+	h, v   int  // sampling factors
+	tq     byte // quantization table
+	bw, bh int  // the size of blocks in blocks, padded to whole MCUs
+	blocks []block
+}
+
+// file is a decoded jpeg file
+type file struct {
+	width, height int
+	sof           byte // the SOF marker; baseline or extended sequential
+	hmax, vmax    int
+	comps         []*component
+	quant         [4]*quantTable
+	markers       [][]byte // APPn and COM segments, including the marker and length, in file order
+}
+
+type huffDecoder struct {
+	maxcode [17]int32 // the largest code of each length, or -1
+	valptr  [17]int32
+	mincode [17]int32
+	vals    []byte
+}
+
+func decode(data []byte) (*file, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrCorrupt
+	}
+	f := &file{}
+	var dc, ac [4]*huffDecoder
+	var restartInterval int
+	pos := 2
+	for {
+		// skip to the next marker, allowing fill bytes
+		for pos < len(data) && data[pos] != 0xff {
+			pos++
+		}
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, ErrCorrupt
+		}
+		marker := data[pos]
+		pos++
+		if marker == 0xd9 { // EOI
+			break
+		}
+		if marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 { // markers without a segment
+			continue
+		}
+		if pos+2 > len(data) {
+			return nil, ErrCorrupt
+		}
+		n := int(binary.BigEndian.Uint16(data[pos:]))
+		if n < 2 || pos+n > len(data) {
+			return nil, ErrCorrupt
+		}
+		seg := data[pos+2 : pos+n]
+		var err error
+		switch {
+		case marker >= 0xe0 && marker <= 0xef || marker == 0xfe: // APPn, COM
+			f.markers = append(f.markers, data[pos-2:pos+n])
+		case marker == 0xdb: // DQT
+			err = f.parseDQT(seg)
+		case marker == 0xc4: // DHT
+			err = parseDHT(seg, &dc, &ac)
+		case marker == 0xdd: // DRI
+			if len(seg) < 2 {
+				return nil, ErrCorrupt
+			}
+			restartInterval = int(binary.BigEndian.Uint16(seg))
+		case marker == 0xc0 || marker == 0xc1: // SOF0, SOF1
+			err = f.parseSOF(marker, seg)
+		case marker == 0xc2:
+			return nil, ErrProgressive
+		case marker >= 0xc3 && marker <= 0xcf && marker != 0xc8 && marker != 0xcc:
+			return nil, ErrUnsupported
+		case marker == 0xda: // SOS
+			if f.comps == nil {
+				return nil, ErrCorrupt
+			}
+			pos, err = f.decodeScan(data, pos+n, seg, &dc, &ac, restartInterval)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+	}
+	if f.comps == nil {
+		return nil, ErrCorrupt
+	}
+	return f, nil
+}
+
+func (f *file) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 || pq > 1 {
+			return ErrCorrupt
+		}
+		size := 65 + 64*int(pq)
+		if len(seg) < size {
+			return ErrCorrupt
+		}
+		t := &quantTable{precision: pq}
+		for k := 0; k < 64; k++ {
+			if pq == 0 {
+				t.q[zigzag[k]] = uint16(seg[1+k])
+			} else {
+				t.q[zigzag[k]] = binary.BigEndian.Uint16(seg[1+2*k:])
+			}
+		}
+		f.quant[tq] = t
+		seg = seg[size:]
+	}
+	return nil
+}
+
+func parseDHT(seg []byte, dc, ac *[4]*huffDecoder) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return ErrCorrupt
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		if tc > 1 || th > 3 {
+			return ErrCorrupt
+		}
+		var counts [17]int
+		total := 0
+		for l := 1; l <= 16; l++ {
+			counts[l] = int(seg[l])
+			total += counts[l]
+		}
+		if len(seg) < 17+total {
+			return ErrCorrupt
+		}
+		d := &huffDecoder{vals: append([]byte(nil), seg[17:17+total]...)}
+		// generate the canonical codes, as in Annex C and F.2.2.3 of the specification
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			d.valptr[l] = k
+			d.mincode[l] = code
+			code += int32(counts[l])
+			k += int32(counts[l])
+			if counts[l] == 0 {
+				d.maxcode[l] = -1
+			} else {
+				d.maxcode[l] = code - 1
+			}
+			code <<= 1
+		}
+		if tc == 0 {
+			dc[th] = d
+		} else {
+			ac[th] = d
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (f *file) parseSOF(marker byte, seg []byte) error {
+	if f.comps != nil || len(seg) < 6 {
+		return ErrCorrupt
+	}
+	if seg[0] != 8 {
+		return ErrUnsupported
+	}
+	f.sof = marker
+	f.height = int(binary.BigEndian.Uint16(seg[1:]))
+	f.width = int(binary.BigEndian.Uint16(seg[3:]))
+	nf := int(seg[5])
+	if f.height == 0 || f.width == 0 {
+		// the height is defined by a DNL marker after the first scan
+		return ErrUnsupported
+	}
+	if nf == 0 || nf > 4 || len(seg) < 6+3*nf {
+		return ErrCorrupt
+	}
+	f.hmax, f.vmax = 1, 1
+	for i := 0; i < nf; i++ {
+		c := &component{
+			id: seg[6+3*i],
+			h:  int(seg[7+3*i] >> 4),
+			v:  int(seg[7+3*i] & 15),
+			tq: seg[8+3*i],
+		}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return ErrCorrupt
+		}
+		if nf == 1 {
+			// a single component is always coded one block at a time, whatever its sampling factors
+			c.h, c.v = 1, 1
+		}
+		f.hmax, f.vmax = max(f.hmax, c.h), max(f.vmax, c.v)
+		f.comps = append(f.comps, c)
+	}
+	f.allocBlocks()
+	return nil
+}
+
+// allocBlocks allocates the blocks of every component for the current image size and sampling factors
+func (f *file) allocBlocks() {
+	mcusX := (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+	mcusY := (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+	for _, c := range f.comps {
+		c.bw, c.bh = mcusX*c.h, mcusY*c.v
+		c.blocks = make([]block, c.bw*c.bh)
+	}
+}
+
+type bitReader struct {
+	data   []byte
+	pos    int
+	acc    uint32 // the next n bits, most significant first
+	n      int
+	marker bool // a marker was reached; zeros are returned from now on
+}
+
+func (br *bitReader) fill() {
+	for br.n <= 24 {
+		var b byte
+		if !br.marker && br.pos < len(br.data) {
+			b = br.data[br.pos]
+			if b == 0xff {
+				if br.pos+1 < len(br.data) && br.data[br.pos+1] == 0 {
+					br.pos += 2 // stuffed zero byte
+				} else {
+					br.marker = true
+					b = 0
+				}
+			} else {
+				br.pos++
+			}
+		}
+		br.acc |= uint32(b) << (24 - br.n)
+		br.n += 8
+	}
+}
+
+func (br *bitReader) bits(s int) int32 {
+	if s == 0 {
+		return 0
+	}
+	if br.n < s {
+		br.fill()
+	}
+	v := int32(br.acc >> (32 - s))
+	br.acc <<= s
+	br.n -= s
+	return v
+}
+
+// receiveExtend reads s bits and converts them to a signed value, as in Annex F.2.2.1
+func (br *bitReader) receiveExtend(s int) int32 {
+	v := br.bits(s)
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v
+}
+
+func (br *bitReader) decodeHuff(d *huffDecoder) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | br.bits(1)
+		if code <= d.maxcode[l] {
+			return d.vals[d.valptr[l]+code-d.mincode[l]], nil
+		}
+	}
+	return 0, ErrCorrupt
+}
+
+// restart skips the restart marker expected at the current position
+func (br *bitReader) restart() error {
+	br.acc, br.n, br.marker = 0, 0, false
+	for br.pos < len(br.data) && br.data[br.pos] == 0xff && br.pos+1 < len(br.data) && br.data[br.pos+1] == 0xff {
+		br.pos++
+	}
+	if br.pos+1 >= len(br.data) || br.data[br.pos] != 0xff || br.data[br.pos+1] < 0xd0 || br.data[br.pos+1] > 0xd7 {
+		return ErrCorrupt // a missing restart marker
+	}
+	br.pos += 2
+	return nil
+}
+
+func (f *file) decodeScan(data []byte, start int, seg []byte, dc, ac *[4]*huffDecoder, restartInterval int) (int, error) {
+	if len(seg) < 1 {
+		return 0, ErrCorrupt
+	}
+	ns := int(seg[0])
+	if ns < 1 || ns > 4 || len(seg) < 4+2*ns {
+		return 0, ErrCorrupt
+	}
+	if seg[1+2*ns] != 0 || seg[2+2*ns] != 63 || seg[3+2*ns] != 0 {
+		// spectral selection or successive approximation; not allowed in sequential files
+		return 0, ErrCorrupt
+	}
+	type scanComp struct {
+		c      *component
+		dc, ac *huffDecoder
+		pred   int32
+	}
+	comps := make([]*scanComp, ns)
+	for i := range comps {
+		id, t := seg[1+2*i], seg[2+2*i]
+		for _, c := range f.comps {
+			if c.id == id {
+				comps[i] = &scanComp{c: c, dc: dc[(t>>4)&3], ac: ac[t&3]}
+			}
+		}
+		if comps[i] == nil || comps[i].dc == nil || comps[i].ac == nil {
+			return 0, ErrCorrupt
+		}
+	}
+
+	br := &bitReader{data: data, pos: start}
+	decodeBlock := func(sc *scanComp, b *block) error {
+		s, err := br.decodeHuff(sc.dc)
+		if err != nil {
+			return err
+		}
+		if s > 15 {
+			return ErrCorrupt
+		}
+		sc.pred += br.receiveExtend(int(s))
+		b[0] = int16(sc.pred)
+		for k := 1; k < 64; k++ {
+			rs, err := br.decodeHuff(sc.ac)
+			if err != nil {
+				return err
+			}
+			r, s := int(rs>>4), int(rs&15)
+			if s == 0 {
+				if r != 15 {
+					break // end of block
+				}
+				k += 15
+				continue
+			}
+			k += r
+			if k > 63 {
+				return ErrCorrupt
+			}
+			b[zigzag[k]] = int16(br.receiveExtend(s))
+		}
+		return nil
+	}
+
+	// a scan with one component is coded block by block over just the blocks that cover the image;
+	// otherwise, it is coded MCU by MCU
+	var mcusX, mcusY int
+	if ns == 1 {
+		c := comps[0].c
+		mcusX = ((f.width*c.h+f.hmax-1)/f.hmax + 7) / 8
+		mcusY = ((f.height*c.v+f.vmax-1)/f.vmax + 7) / 8
+	} else {
+		mcusX = (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+		mcusY = (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+	}
+	mcu := 0
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if restartInterval > 0 && mcu > 0 && mcu%restartInterval == 0 {
+				if err := br.restart(); err != nil {
+					return 0, err
+				}
+				for _, sc := range comps {
+					sc.pred = 0
+				}
+			}
+			mcu++
+			if ns == 1 {
+				c := comps[0].c
+				if err := decodeBlock(comps[0], &c.blocks[my*c.bw+mx]); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			for _, sc := range comps {
+				c := sc.c
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						if err := decodeBlock(sc, &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h]); err != nil {
+							return 0, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// find the marker that ends the scan
+	pos := br.pos
+	for pos+1 < len(data) {
+		if data[pos] == 0xff && data[pos+1] != 0 && (data[pos+1] < 0xd0 || data[pos+1] > 0xd7) && data[pos+1] != 0xff {
+			return pos, nil
+		}
+		pos++
+	}
+	return 0, ErrCorrupt
+}
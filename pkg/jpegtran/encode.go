@@ -0,0 +1,298 @@
+package jpegtran
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// huffTable is a Huffman table in the form it is written to a DHT segment, with the code of each symbol
+type huffTable struct {
+	counts [17]byte // the number of codes of each length
+	vals   []byte
+	code   [256]uint16
+	size   [256]byte
+}
+
+// newOptimalTable builds the optimal Huffman table for the symbol frequencies freq, as in Annex K.2
+// of the specification (and libjpeg's jpeg_gen_optimal_table)
+func newOptimalTable(freq [256]int) *huffTable {
+	var f [257]int
+	copy(f[:], freq[:])
+	f[256] = 1 // reserves one code point, so that no code consists of only 1 bits
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// find the two least frequent symbols, preferring higher indexes among equals
+		c1, c2 := -1, -1
+		for i := 0; i < 257; i++ {
+			if f[i] > 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i := 0; i < 257; i++ {
+			if f[i] > 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+	var count [33]int
+	for i := 0; i < 257; i++ {
+		if codesize[i] > 0 {
+			count[codesize[i]]++
+		}
+	}
+	// limit the code lengths to 16 bits
+	for i := 32; i > 16; i-- {
+		for count[i] > 0 {
+			j := i - 2
+			for count[j] == 0 {
+				j--
+			}
+			count[i] -= 2
+			count[i-1]++
+			count[j+1] += 2
+			count[j]--
+		}
+	}
+	// remove the reserved code point from the longest codes
+	i := 16
+	for count[i] == 0 {
+		i--
+	}
+	count[i]--
+
+	t := &huffTable{}
+	for l := 1; l <= 16; l++ {
+		t.counts[l] = byte(count[l])
+	}
+	for l := 1; l <= 32; l++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == l {
+				t.vals = append(t.vals, byte(s))
+			}
+		}
+	}
+	// assign the canonical codes
+	code, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for n := 0; n < int(t.counts[l]); n++ {
+			s := t.vals[k]
+			t.code[s], t.size[s] = code, byte(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+type bitWriter struct {
+	w   *bufio.Writer
+	acc uint32
+	n   int
+}
+
+func (bw *bitWriter) write(v uint32, size int) {
+	bw.acc = bw.acc<<size | v&(1<<size-1)
+	bw.n += size
+	for bw.n >= 8 {
+		b := byte(bw.acc >> (bw.n - 8))
+		bw.w.WriteByte(b)
+		if b == 0xff {
+			bw.w.WriteByte(0) // byte stuffing
+		}
+		bw.n -= 8
+	}
+}
+
+// flush pads the last byte with 1 bits
+func (bw *bitWriter) flush() {
+	if bw.n > 0 {
+		bw.write(1<<(8-bw.n)-1, 8-bw.n)
+	}
+}
+
+// category returns the number of bits needed for the magnitude of v and the bits that encode v
+func category(v int32) (int, uint32) {
+	a := v
+	if v < 0 {
+		a = -v
+		v--
+	}
+	s := bits.Len32(uint32(a))
+	return s, uint32(v) & (1<<s - 1)
+}
+
+// emitter receives the symbols of a scan; it either counts them or writes them
+type emitter interface {
+	symbol(t int, s byte)
+	bits(v uint32, size int)
+}
+
+type counter struct{ freq [4][256]int } // indexed by table: DC 0, AC 0, DC 1, AC 1
+
+func (c *counter) symbol(t int, s byte) { c.freq[t][s]++ }
+func (c *counter) bits(uint32, int)     {}
+
+type writer struct {
+	bw     *bitWriter
+	tables [4]*huffTable
+}
+
+func (w *writer) symbol(t int, s byte) {
+	w.bw.write(uint32(w.tables[t].code[s]), int(w.tables[t].size[s]))
+}
+func (w *writer) bits(v uint32, size int) { w.bw.write(v, size) }
+
+// tableIndex returns the DC table index used for component i; the AC table follows it
+func tableIndex(i int) int {
+	if i == 0 {
+		return 0
+	}
+	return 2
+}
+
+// scan walks the blocks of the image in coding order, as a single scan of all components
+func (f *file) scan(e emitter) {
+	preds := make([]int32, len(f.comps))
+	encodeBlock := func(i int, b *block) {
+		t := tableIndex(i)
+		s, v := category(int32(b[0]) - preds[i])
+		preds[i] = int32(b[0])
+		e.symbol(t, byte(s))
+		e.bits(v, s)
+		run := 0
+		for k := 1; k < 64; k++ {
+			c := b[zigzag[k]]
+			if c == 0 {
+				run++
+				continue
+			}
+			for run > 15 {
+				e.symbol(t+1, 0xf0)
+				run -= 16
+			}
+			s, v := category(int32(c))
+			e.symbol(t+1, byte(run<<4|s))
+			e.bits(v, s)
+			run = 0
+		}
+		if run > 0 {
+			e.symbol(t+1, 0x00) // end of block
+		}
+	}
+	if len(f.comps) == 1 {
+		// a single component is coded over just the blocks that cover the image
+		c := f.comps[0]
+		for by := 0; by < (f.height+7)/8; by++ {
+			for bx := 0; bx < (f.width+7)/8; bx++ {
+				encodeBlock(0, &c.blocks[by*c.bw+bx])
+			}
+		}
+		return
+	}
+	mcusX := (f.width + 8*f.hmax - 1) / (8 * f.hmax)
+	mcusY := (f.height + 8*f.vmax - 1) / (8 * f.vmax)
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			for i, c := range f.comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						encodeBlock(i, &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h])
+					}
+				}
+			}
+		}
+	}
+}
+
+func writeSegment(w *bufio.Writer, marker byte, payload []byte) {
+	w.Write([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	w.Write(payload)
+}
+
+func (f *file) encode(dst io.Writer) error {
+	// gather the symbol frequencies for the optimal Huffman tables
+	var cnt counter
+	f.scan(&cnt)
+	var tables [4]*huffTable
+	for i := range tables {
+		if i < 2 || len(f.comps) > 1 {
+			tables[i] = newOptimalTable(cnt.freq[i])
+		}
+	}
+
+	w := bufio.NewWriter(dst)
+	w.Write([]byte{0xff, 0xd8})
+	for _, m := range f.markers {
+		w.Write(m)
+	}
+
+	var dqt []byte
+	for i, q := range f.quant {
+		if q == nil {
+			continue
+		}
+		dqt = append(dqt, q.precision<<4|byte(i))
+		for k := 0; k < 64; k++ {
+			v := q.q[zigzag[k]]
+			if q.precision == 0 {
+				dqt = append(dqt, byte(v))
+			} else {
+				dqt = append(dqt, byte(v>>8), byte(v))
+			}
+		}
+	}
+	writeSegment(w, 0xdb, dqt)
+
+	sof := []byte{8, byte(f.height >> 8), byte(f.height), byte(f.width >> 8), byte(f.width), byte(len(f.comps))}
+	for _, c := range f.comps {
+		sof = append(sof, c.id, byte(c.h<<4|c.v), c.tq)
+	}
+	writeSegment(w, f.sof, sof)
+
+	var dht []byte
+	for i, t := range tables {
+		if t == nil {
+			continue
+		}
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, t.counts[1:]...)
+		dht = append(dht, t.vals...)
+	}
+	writeSegment(w, 0xc4, dht)
+
+	sos := []byte{byte(len(f.comps))}
+	for i, c := range f.comps {
+		t := byte(tableIndex(i) / 2)
+		sos = append(sos, c.id, t<<4|t)
+	}
+	sos = append(sos, 0, 63, 0)
+	writeSegment(w, 0xda, sos)
+
+	bw := &bitWriter{w: w}
+	f.scan(&writer{bw: bw, tables: tables})
+	bw.flush()
+	w.Write([]byte{0xff, 0xd9})
+	return w.Flush()
+}
@@ -0,0 +1,100 @@
+// Package jpegtran rotates, flips, and crops baseline jpeg files without decoding them to pixels.
+// The DCT coefficients are rearranged and re-encoded with optimized Huffman tables, so no quality is lost.
+package jpegtran
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+var (
+	// ErrProgressive is returned for progressive jpeg files, which are not supported.
+	ErrProgressive = errors.New("jpegtran: progressive jpeg files are not supported")
+	// ErrUnsupported is returned for other jpeg files that cannot be transformed, e.g. arithmetic coded or 12-bit files.
+	ErrUnsupported = errors.New("jpegtran: unsupported jpeg file")
+	// ErrCorrupt is returned for jpeg files that cannot be parsed. The parser is stricter than image/jpeg, so
+	// some files that image/jpeg decodes are rejected.
+	ErrCorrupt = errors.New("jpegtran: invalid jpeg data")
+	// ErrImperfect is returned if Options.Perfect is set and the result would not match the requested transform
+	// and crop exactly.
+	ErrImperfect = errors.New("jpegtran: the transform or crop is not aligned to whole MCUs")
+)
+
+// Transform is one of the eight combinations of 90 degree rotations and flips.
+// The values match the EXIF orientation tag.
+type Transform int
+
+const (
+	None       Transform = iota + 1
+	FlipH                // mirror left to right
+	Rotate180            // rotate 180 degrees
+	FlipV                // mirror top to bottom
+	Transpose            // mirror along the top-left to bottom-right diagonal
+	Rotate90             // rotate 90 degrees clockwise
+	Transverse           // mirror along the top-right to bottom-left diagonal
+	Rotate270            // rotate 270 degrees clockwise
+)
+
+// how each transform maps destination coordinates to source coordinates:
+// swap exchanges x and y, and flipX and flipY then mirror the source x and y
+var transformMaps = [...]struct{ swap, flipX, flipY bool }{
+	None:       {false, false, false},
+	FlipH:      {false, true, false},
+	Rotate180:  {false, true, true},
+	FlipV:      {false, false, true},
+	Transpose:  {true, false, false},
+	Rotate90:   {true, false, true},
+	Transverse: {true, true, true},
+	Rotate270:  {true, true, false},
+}
+
+type Options struct {
+	Transform Transform
+	// Crop is applied after Transform, in the coordinates of the transformed image; an empty rectangle
+	// disables cropping. The top-left corner is moved up and left to the nearest MCU boundary (a multiple of
+	// 8 or 16 pixels), since blocks cannot be split. The crop is clipped to the image bounds.
+	Crop image.Rectangle
+	// Perfect makes Apply fail with ErrImperfect, like jpegtran -perfect, instead of dropping partial MCUs on
+	// mirrored edges or moving the top-left corner of the crop, so that the result is exactly what was requested.
+	Perfect bool
+}
+
+// Apply reads a jpeg file from r, transforms it according to opts, and writes the result to w.
+// Flips and rotations that mirror an edge with a partial MCU drop that partial MCU, like jpegtran -trim,
+// since it would otherwise end up inside the image. EXIF orientation tags are reset to normal if the
+// image is rotated or flipped; other metadata is copied unchanged. Errors other than those returned by r and w
+// are ErrProgressive, ErrUnsupported, ErrCorrupt, or ErrImperfect.
+func Apply(w io.Writer, r io.Reader, opts Options) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f, err := decode(data)
+	if err != nil {
+		return err
+	}
+	if opts.Transform < None || opts.Transform > Rotate270 {
+		opts.Transform = None
+	}
+	if opts.Transform != None {
+		if opts.Perfect && !f.perfect(opts.Transform) {
+			return ErrImperfect
+		}
+		if err = f.transform(opts.Transform); err != nil {
+			return err
+		}
+		for i, m := range f.markers {
+			f.markers[i] = resetOrientation(m)
+		}
+	}
+	if !opts.Crop.Empty() {
+		if opts.Perfect && (opts.Crop.Min.X%(8*f.hmax) != 0 || opts.Crop.Min.Y%(8*f.vmax) != 0) {
+			return ErrImperfect
+		}
+		if err = f.crop(opts.Crop); err != nil {
+			return err
+		}
+	}
+	return f.encode(w)
+}
@@ -0,0 +1,187 @@
+package jpegtran
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testJpeg returns a w x h jpeg file with a pattern that has detail in every block. Color images are
+// written with 4:2:0 chroma subsampling (16x16 MCUs) by image/jpeg, and gray images with 8x8 MCUs.
+func testJpeg(t *testing.T, w, h int, gray bool) []byte {
+	t.Helper()
+	var m setter = image.NewRGBA(image.Rect(0, 0, w, h))
+	if gray {
+		m = image.NewGray(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x ^ y) * 8), 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type setter interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}
+
+func decodeJpeg(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func apply(t *testing.T, data []byte, opts Options) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Apply(&buf, bytes.NewReader(data), opts); err != nil {
+		t.Fatal(err)
+	}
+	return decodeJpeg(t, buf.Bytes())
+}
+
+// transformed returns src transformed in the pixel domain, as a reference
+func transformed(src image.Image, tr Transform) image.Image {
+	m := transformMaps[tr]
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if m.swap {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ux, uy := x, y
+			if m.swap {
+				ux, uy = y, x
+			}
+			if m.flipX {
+				ux = b.Dx() - 1 - ux
+			}
+			if m.flipY {
+				uy = b.Dy() - 1 - uy
+			}
+			dst.Set(x, y, src.At(b.Min.X+ux, b.Min.Y+uy))
+		}
+	}
+	return dst
+}
+
+// maxDiff returns the largest difference between the 8-bit channels of got and want, which must be the same size
+func maxDiff(t *testing.T, got, want image.Image) int {
+	t.Helper()
+	gb, wb := got.Bounds(), want.Bounds()
+	if gb.Size() != wb.Size() {
+		t.Fatalf("got size %v, want %v", gb.Size(), wb.Size())
+	}
+	d := 0
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			r0, g0, b0, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r1, g1, b1, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			for _, v := range [][2]uint32{{r0, r1}, {g0, g1}, {b0, b1}} {
+				d = max(d, abs(int(v[0]>>8)-int(v[1]>>8)))
+			}
+		}
+	}
+	return d
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// the Huffman coding round trip must not change any coefficient, so the decoded pixels are identical
+func TestRoundTrip(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		data := testJpeg(t, 70, 50, gray)
+		if d := maxDiff(t, apply(t, data, Options{}), decodeJpeg(t, data)); d != 0 {
+			t.Errorf("gray=%v: decoded pixels differ by up to %d", gray, d)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		data := testJpeg(t, 64, 48, gray) // whole MCUs
+		src := decodeJpeg(t, data)
+		for tr := None; tr <= Rotate270; tr++ {
+			got := apply(t, data, Options{Transform: tr, Perfect: true})
+			// the inverse DCT of image/jpeg rounds mirrored blocks slightly differently, and the conversion
+			// from YCbCr adds to that; a misplaced block or coefficient would differ by much more
+			if d := maxDiff(t, got, transformed(src, tr)); d > 3 {
+				t.Errorf("gray=%v transform=%d: decoded pixels differ by up to %d", gray, tr, d)
+			}
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	for _, tc := range []struct {
+		crop, want image.Rectangle
+	}{
+		{image.Rect(16, 16, 36, 36), image.Rect(16, 16, 36, 36)},
+		{image.Rect(48, 32, 100, 100), image.Rect(48, 32, 70, 50)}, // clipped to the image
+		{image.Rect(0, 0, 70, 50), image.Rect(0, 0, 70, 50)},
+	} {
+		data := testJpeg(t, 70, 50, false)
+		got := apply(t, data, Options{Crop: tc.crop, Perfect: true})
+		want := decodeJpeg(t, data).(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(tc.want)
+		if d := maxDiff(t, got, want); d != 0 {
+			t.Errorf("crop %v: decoded pixels differ by up to %d", tc.crop, d)
+		}
+	}
+}
+
+// the crop is in the coordinates of the transformed image
+func TestTransformCrop(t *testing.T) {
+	data := testJpeg(t, 64, 48, false)
+	crop := image.Rect(16, 32, 40, 60)
+	got := apply(t, data, Options{Transform: Rotate90, Crop: crop, Perfect: true})
+	want := transformed(decodeJpeg(t, data), Rotate90).(*image.RGBA).SubImage(crop)
+	if d := maxDiff(t, got, want); d > 3 {
+		t.Errorf("decoded pixels differ by up to %d", d)
+	}
+}
+
+func TestPerfect(t *testing.T) {
+	data := testJpeg(t, 70, 50, false)
+	for _, opts := range []Options{
+		{Transform: FlipH, Perfect: true},                 // 70 is not a multiple of 16
+		{Transform: Rotate90, Perfect: true},              // mirrors the 50 pixel edge
+		{Crop: image.Rect(10, 10, 30, 30), Perfect: true}, // not on an MCU boundary
+		{Transform: Transpose, Crop: image.Rect(8, 0, 20, 20), Perfect: true},
+	} {
+		if err := Apply(new(bytes.Buffer), bytes.NewReader(data), opts); !errors.Is(err, ErrImperfect) {
+			t.Errorf("%+v: got error %v, want ErrImperfect", opts, err)
+		}
+	}
+	// without Perfect, the partial MCU is dropped and the crop is moved
+	if got := apply(t, data, Options{Transform: FlipH}).Bounds().Size(); got != image.Pt(64, 50) {
+		t.Errorf("flip: got size %v, want 64x50", got)
+	}
+	if got := apply(t, data, Options{Crop: image.Rect(10, 10, 30, 30)}).Bounds().Size(); got != image.Pt(30, 30) {
+		t.Errorf("crop: got size %v, want 30x30", got)
+	}
+	// flips of gray images only need whole 8x8 blocks
+	if got := apply(t, testJpeg(t, 72, 48, true), Options{Transform: FlipH, Perfect: true}).Bounds().Size(); got != image.Pt(72, 48) {
+		t.Errorf("gray flip: got size %v, want 72x48", got)
+	}
+}
@@ -0,0 +1,174 @@
+package jpegtran
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// perfect reports whether t mirrors only edges that end on an MCU boundary, so that no partial MCU is dropped
+func (f *file) perfect(t Transform) bool {
+	m := transformMaps[t]
+	return !(m.flipX && f.width%(8*f.hmax) != 0) && !(m.flipY && f.height%(8*f.vmax) != 0)
+}
+
+// transform rearranges the blocks of every component, and the coefficients within them, according to t.
+// Mirroring a block negates its odd horizontal or vertical frequencies, and transposing it swaps them.
+func (f *file) transform(t Transform) error {
+	m := transformMaps[t]
+	mcuW, mcuH := 8*f.hmax, 8*f.vmax
+	// a partial MCU on a mirrored edge would move inside the image, so it is dropped
+	if m.flipX {
+		f.width -= f.width % mcuW
+	}
+	if m.flipY {
+		f.height -= f.height % mcuH
+	}
+	if f.width == 0 || f.height == 0 {
+		return ErrUnsupported // smaller than one MCU
+	}
+
+	type source struct {
+		blocks []block
+		bw, bh int
+		nx, ny int // the number of blocks covering the trimmed image
+	}
+	srcs := make([]source, len(f.comps))
+	for i, c := range f.comps {
+		srcs[i] = source{c.blocks, c.bw, c.bh, f.width * c.h / f.hmax / 8, f.height * c.v / f.vmax / 8}
+	}
+	if m.swap {
+		f.width, f.height = f.height, f.width
+		f.hmax, f.vmax = f.vmax, f.hmax
+		for _, c := range f.comps {
+			c.h, c.v = c.v, c.h
+		}
+		for _, q := range f.quant {
+			if q != nil {
+				q.q = transposed(q.q)
+			}
+		}
+	}
+	f.allocBlocks()
+
+	// the coefficient of each destination frequency comes from the source frequency idx,
+	// negated if neg is set
+	var idx [64]int
+	var neg [64]bool
+	for fv := 0; fv < 8; fv++ {
+		for fu := 0; fu < 8; fu++ {
+			sh, sv := fu, fv // source horizontal and vertical frequencies
+			if m.swap {
+				sh, sv = fv, fu
+			}
+			idx[fv*8+fu] = sv*8 + sh
+			negX, negY := m.flipX && sh%2 == 1, m.flipY && sv%2 == 1
+			neg[fv*8+fu] = negX != negY
+		}
+	}
+
+	for i, c := range f.comps {
+		src := srcs[i]
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				ux, uy := bx, by
+				if m.swap {
+					ux, uy = by, bx
+				}
+				if m.flipX {
+					ux = src.nx - 1 - ux
+				}
+				if m.flipY {
+					uy = src.ny - 1 - uy
+				}
+				if ux < 0 || uy < 0 || ux >= src.bw || uy >= src.bh {
+					continue // padding outside the image
+				}
+				sb, db := &src.blocks[uy*src.bw+ux], &c.blocks[by*c.bw+bx]
+				for k := range db {
+					if neg[k] {
+						db[k] = -sb[idx[k]]
+					} else {
+						db[k] = sb[idx[k]]
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func transposed(q [64]uint16) [64]uint16 {
+	var t [64]uint16
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			t[x*8+y] = q[y*8+x]
+		}
+	}
+	return t
+}
+
+// crop keeps the blocks covering r, after moving its top-left corner to an MCU boundary
+func (f *file) crop(r image.Rectangle) error {
+	mcuW, mcuH := 8*f.hmax, 8*f.vmax
+	r.Min.X -= r.Min.X % mcuW
+	r.Min.Y -= r.Min.Y % mcuH
+	r = r.Intersect(image.Rect(0, 0, f.width, f.height))
+	if r.Empty() {
+		return ErrUnsupported
+	}
+	type source struct {
+		blocks         []block
+		bw, bh, x0, y0 int
+	}
+	srcs := make([]source, len(f.comps))
+	for i, c := range f.comps {
+		srcs[i] = source{c.blocks, c.bw, c.bh, r.Min.X / mcuW * c.h, r.Min.Y / mcuH * c.v}
+	}
+	f.width, f.height = r.Dx(), r.Dy()
+	f.allocBlocks()
+	for i, c := range f.comps {
+		src := srcs[i]
+		for by := 0; by < c.bh && src.y0+by < src.bh; by++ {
+			n := min(c.bw, src.bw-src.x0)
+			row := (src.y0+by)*src.bw + src.x0
+			copy(c.blocks[by*c.bw:by*c.bw+n], src.blocks[row:row+n])
+		}
+	}
+	return nil
+}
+
+// resetOrientation returns a copy of an APP1 Exif segment with its orientation tag set to normal;
+// other segments are returned unchanged
+func resetOrientation(seg []byte) []byte {
+	const tiff = 10 // the offset of the TIFF header: marker, length, and "Exif\x00\x00"
+	if len(seg) < tiff+8 || seg[1] != 0xe1 || string(seg[4:10]) != "Exif\x00\x00" {
+		return seg
+	}
+	var order binary.ByteOrder
+	switch string(seg[tiff : tiff+2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return seg
+	}
+	ifd := tiff + int(order.Uint32(seg[tiff+4:]))
+	if ifd < tiff || ifd+2 > len(seg) {
+		return seg
+	}
+	n := int(order.Uint16(seg[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(seg) {
+			break
+		}
+		// the orientation tag holds one SHORT value
+		if order.Uint16(seg[e:]) == 0x0112 && order.Uint16(seg[e+2:]) == 3 {
+			out := append([]byte(nil), seg...)
+			order.PutUint16(out[e+8:], 1)
+			return out
+		}
+	}
+	return seg
+}
@@ -0,0 +1,35 @@
+//go:build !cgo || !jxl
+
+package jxl
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// libjxl is used in both directions, so decoding and encoding are enabled together
+const (
+	DECODE_ENABLED = false
+	ENCODE_ENABLED = false
+)
+
+var errNotEnabled = errors.New("jpeg xl support is not enabled; review docs at github.com/cdillond/imgconv for details")
+
+func Decode(r io.Reader) (image.Image, error) {
+	return nil, errNotEnabled
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	return image.Config{}, errNotEnabled
+}
+
+func EncodeJXL(w io.Writer, img image.Image, opt JXLOptions) error {
+	// this code should be unreachable
+	return errNotEnabled
+}
+
+func TranscodeJPEG(w io.Writer, jpeg []byte, opt JXLOptions) error {
+	// this code should be unreachable
+	return errNotEnabled
+}
@@ -0,0 +1,322 @@
+//go:build cgo && jxl
+
+package jxl
+
+/*
+	#cgo LDFLAGS: -ljxl
+	#include <jxl/decode.h>
+	#include <jxl/encode.h>
+	#include <stdlib.h>
+
+// drains the encoder into a buffer allocated with malloc; returns 0 on failure
+static int processOutput(JxlEncoder* enc, uint8_t** out, size_t* size) {
+	size_t cap = 1 << 16;
+	size_t offset;
+	uint8_t* tmp;
+	uint8_t* buf = malloc(cap);
+	if (buf == NULL) {
+		return 0;
+	}
+	uint8_t* next = buf;
+	size_t avail = cap;
+
+	JxlEncoderStatus status = JXL_ENC_NEED_MORE_OUTPUT;
+	while (status == JXL_ENC_NEED_MORE_OUTPUT) {
+		status = JxlEncoderProcessOutput(enc, &next, &avail);
+		if (status == JXL_ENC_NEED_MORE_OUTPUT) {
+			offset = next - buf;
+			cap *= 2;
+			tmp = realloc(buf, cap);
+			if (tmp == NULL) {
+				free(buf);
+				return 0;
+			}
+			buf = tmp;
+			next = buf + offset;
+			avail = cap - offset;
+		}
+	}
+	if (status != JXL_ENC_SUCCESS) {
+		free(buf);
+		return 0;
+	}
+	*out = buf;
+	*size = next - buf;
+	return 1;
+}
+
+int encodePixels(const uint8_t* pixels, int width, int height, int channels, float distance, int effort, int lossless, uint8_t** out, size_t* size) {
+	int ok = 0;
+	JxlBasicInfo info;
+	JxlColorEncoding color;
+	JxlPixelFormat format = {channels, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+	JxlEncoderFrameSettings* settings;
+
+	JxlEncoder* enc = JxlEncoderCreate(NULL);
+	if (enc == NULL) {
+		return 0;
+	}
+
+	JxlEncoderInitBasicInfo(&info);
+	info.xsize = width;
+	info.ysize = height;
+	info.bits_per_sample = 8;
+	info.num_color_channels = 3;
+	if (channels == 4) {
+		info.num_extra_channels = 1;
+		info.alpha_bits = 8;
+	}
+	info.uses_original_profile = lossless ? JXL_TRUE : JXL_FALSE;
+	if (JxlEncoderSetBasicInfo(enc, &info) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlColorEncodingSetToSRGB(&color, JXL_FALSE);
+	if (JxlEncoderSetColorEncoding(enc, &color) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+
+	settings = JxlEncoderFrameSettingsCreate(enc, NULL);
+	JxlEncoderFrameSettingsSetOption(settings, JXL_ENC_FRAME_SETTING_EFFORT, effort);
+	if (lossless) {
+		JxlEncoderSetFrameLossless(settings, JXL_TRUE);
+	} else if (JxlEncoderSetFrameDistance(settings, distance) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	if (JxlEncoderAddImageFrame(settings, &format, pixels, (size_t)width * height * channels) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlEncoderCloseInput(enc);
+	ok = processOutput(enc, out, size);
+
+done:
+	JxlEncoderDestroy(enc);
+	return ok;
+}
+
+int transcodeJPEG(const uint8_t* jpeg, size_t jpeg_size, int effort, uint8_t** out, size_t* size) {
+	int ok = 0;
+	JxlEncoderFrameSettings* settings;
+
+	JxlEncoder* enc = JxlEncoderCreate(NULL);
+	if (enc == NULL) {
+		return 0;
+	}
+	// the jpeg reconstruction data is stored in a box, so the container format is required
+	JxlEncoderUseContainer(enc, JXL_TRUE);
+	if (JxlEncoderStoreJPEGMetadata(enc, JXL_TRUE) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	settings = JxlEncoderFrameSettingsCreate(enc, NULL);
+	JxlEncoderFrameSettingsSetOption(settings, JXL_ENC_FRAME_SETTING_EFFORT, effort);
+	if (JxlEncoderAddJPEGFrame(settings, jpeg, jpeg_size) != JXL_ENC_SUCCESS) {
+		goto done;
+	}
+	JxlEncoderCloseInput(enc);
+	ok = processOutput(enc, out, size);
+
+done:
+	JxlEncoderDestroy(enc);
+	return ok;
+}
+
+// decodes the first frame of data to 8-bit non-premultiplied RGBA; if info_only is set, only the dimensions are read
+int decodeRGBA(const uint8_t* data, size_t size, int info_only, uint32_t* width, uint32_t* height, uint8_t** pixels, size_t* pixels_size) {
+	int ok = 0;
+	int running = 1;
+	int events = JXL_DEC_BASIC_INFO;
+	JxlBasicInfo info;
+	JxlDecoderStatus status;
+	JxlPixelFormat format = {4, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+	uint8_t* buf = NULL;
+
+	JxlDecoder* dec = JxlDecoderCreate(NULL);
+	if (dec == NULL) {
+		return 0;
+	}
+	if (!info_only) {
+		events |= JXL_DEC_FULL_IMAGE;
+	}
+	if (JxlDecoderSubscribeEvents(dec, events) != JXL_DEC_SUCCESS ||
+		JxlDecoderSetInput(dec, data, size) != JXL_DEC_SUCCESS) {
+		JxlDecoderDestroy(dec);
+		return 0;
+	}
+	JxlDecoderCloseInput(dec);
+
+	while (running) {
+		status = JxlDecoderProcessInput(dec);
+		switch (status) {
+		case JXL_DEC_BASIC_INFO:
+			if (JxlDecoderGetBasicInfo(dec, &info) != JXL_DEC_SUCCESS) {
+				running = 0;
+				break;
+			}
+			*width = info.xsize;
+			*height = info.ysize;
+			if (info_only) {
+				ok = 1;
+				running = 0;
+			}
+			break;
+		case JXL_DEC_NEED_IMAGE_OUT_BUFFER:
+			if (JxlDecoderImageOutBufferSize(dec, &format, pixels_size) != JXL_DEC_SUCCESS) {
+				running = 0;
+				break;
+			}
+			buf = malloc(*pixels_size);
+			if (buf == NULL || JxlDecoderSetImageOutBuffer(dec, &format, buf, *pixels_size) != JXL_DEC_SUCCESS) {
+				running = 0;
+			}
+			break;
+		case JXL_DEC_FULL_IMAGE:
+			// only the first frame of animations is decoded, so buf is allocated once
+			ok = 1;
+			running = 0;
+			break;
+		case JXL_DEC_SUCCESS:
+			ok = 1;
+			running = 0;
+			break;
+		default:
+			running = 0;
+		}
+	}
+	JxlDecoderDestroy(dec);
+	if (!ok || info_only) {
+		free(buf);
+		return ok;
+	}
+	*pixels = buf;
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// libjxl is used in both directions, so decoding and encoding are enabled together
+const (
+	DECODE_ENABLED = true
+	ENCODE_ENABLED = true
+)
+
+func init() {
+	// bare codestream and ISOBMFF container signatures
+	image.RegisterFormat("jxl", "\xff\x0a", Decode, DecodeConfig)
+	image.RegisterFormat("jxl", "\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a", Decode, DecodeConfig)
+}
+
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, errors.New("error decoding jxl file; no data")
+	}
+	var width, height C.uint32_t
+	var pixels *C.uint8_t
+	var size C.size_t
+	if C.decodeRGBA((*C.uint8_t)(&data[0]), C.size_t(len(data)), 0, &width, &height, &pixels, &size) == 0 {
+		return nil, errors.New("error decoding jxl file")
+	}
+	defer C.free(unsafe.Pointer(pixels)) // DO NOT FORGET
+
+	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	if len(img.Pix) != int(size) {
+		return nil, errors.New("error decoding jxl file; unexpected pixel buffer size")
+	}
+	copy(img.Pix, unsafe.Slice((*byte)(unsafe.Pointer(pixels)), int(size)))
+	return img, nil
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if len(data) < 1 {
+		return image.Config{}, errors.New("error decoding jxl file; no data")
+	}
+	var width, height C.uint32_t
+	if C.decodeRGBA((*C.uint8_t)(&data[0]), C.size_t(len(data)), 1, &width, &height, nil, nil) == 0 {
+		return image.Config{}, errors.New("error decoding jxl file header")
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(width), Height: int(height)}, nil
+}
+
+func EncodeJXL(w io.Writer, img image.Image, opt JXLOptions) error {
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	width, height := nrgba.Rect.Dx(), nrgba.Rect.Dy()
+	if width < 1 || height < 1 {
+		return errors.New("error encoding jxl file; image is empty")
+	}
+
+	// libjxl wants tightly packed pixels, so opaque images are repacked as RGB
+	channels := 4
+	if nrgba.Opaque() {
+		channels = 3
+	}
+	pix := make([]byte, 0, width*height*channels)
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		if channels == 4 {
+			pix = append(pix, row...)
+			continue
+		}
+		for x := 0; x < len(row); x += 4 {
+			pix = append(pix, row[x], row[x+1], row[x+2])
+		}
+	}
+
+	var lossless C.int
+	if opt.Lossless || opt.Distance <= 0 { // libjxl needs the lossless mode, not just a distance of 0
+		lossless = 1
+	}
+	var out *C.uint8_t
+	var size C.size_t
+	if C.encodePixels((*C.uint8_t)(&pix[0]),
+		C.int(width),
+		C.int(height),
+		C.int(channels),
+		C.float(opt.Distance),
+		C.int(opt.Effort),
+		lossless,
+		&out,
+		&size) == 0 {
+		return errors.New("error encoding jxl file")
+	}
+	defer C.free(unsafe.Pointer(out)) // DO NOT FORGET
+
+	b := C.GoBytes(unsafe.Pointer(out), C.int(size))
+	_, err := w.Write(b)
+	return err
+}
+
+// TranscodeJPEG losslessly recompresses a jpeg file. The original jpeg can be reconstructed bit for bit from the output.
+func TranscodeJPEG(w io.Writer, jpeg []byte, opt JXLOptions) error {
+	if len(jpeg) < 1 {
+		return errors.New("error transcoding jpeg file; no data")
+	}
+	var out *C.uint8_t
+	var size C.size_t
+	if C.transcodeJPEG((*C.uint8_t)(&jpeg[0]), C.size_t(len(jpeg)), C.int(opt.Effort), &out, &size) == 0 {
+		return errors.New("error transcoding jpeg file to jxl")
+	}
+	defer C.free(unsafe.Pointer(out)) // DO NOT FORGET
+
+	b := C.GoBytes(unsafe.Pointer(out), C.int(size))
+	_, err := w.Write(b)
+	return err
+}
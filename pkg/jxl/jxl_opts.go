@@ -0,0 +1,7 @@
+package jxl
+
+type JXLOptions struct {
+	Distance float32 // butteraugli distance; 0 is mathematically lossless, 1 is visually lossless, 15 is the maximum
+	Effort   int     // 1-9 (fast - slow)
+	Lossless bool    // if true, Distance is ignored and the image is encoded losslessly
+}
@@ -6,11 +6,9 @@ import (
 	"errors"
 	"image"
 	"io"
-
-	"github.com/cdillond/imgconv/pkg/utils"
 )
 
-const MAX_ENCODE_TYPE utils.FileType = 3
+const ENCODE_ENABLED = false
 
 func EncodeWebP(w io.Writer, img image.Image, opt WebPOptions) error {
 	// this code should be unreachable
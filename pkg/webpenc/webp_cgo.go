@@ -6,11 +6,9 @@ import (
 	"image"
 	"image/draw"
 	"io"
-
-	"github.com/cdillond/imgconv/pkg/utils"
 )
 
-const MAX_ENCODE_TYPE utils.FileType = 4
+const ENCODE_ENABLED = true
 
 func EncodeWebP(w io.Writer, img image.Image, opt WebPOptions) error {
 	// Lossless webp is NRGBA, lossy webp is (N)YCbCr(A)
@@ -0,0 +1,56 @@
+package main
+
+import "image"
+
+// ProcessCfg holds every operation applied to a decoded image before it is encoded.
+type ProcessCfg struct {
+	Adjust    AdjustCfg
+	Transform TransformCfg
+	Trim      TrimCfg
+	Resample  ResampleCfg
+	Convolve  ConvolveCfg
+	Composite CompositeCfg
+	Overlay   OverlayCfg
+	Text      TextCfg
+	Frame     FrameCfg
+	Ops       Pipeline // applied in order after the other operations, before flattening (see ParseOp)
+	Flatten   FlattenCfg
+	Color     ColorCfg
+}
+
+// IsUsed reports whether any operation in cfg changes the image. Flatten is not counted,
+// since it does not change opaque images.
+func (cfg ProcessCfg) IsUsed() bool {
+	return cfg.Adjust.IsUsed || cfg.Transform.IsUsed || cfg.Trim.IsUsed || cfg.Resample.IsUsed ||
+		cfg.Convolve.IsUsed || cfg.Composite.IsUsed || cfg.Overlay.IsUsed || cfg.Text.IsUsed ||
+		cfg.Frame.IsUsed || len(cfg.Ops) > 0 || cfg.Color.IsUsed
+}
+
+// reorientsOnly reports whether cfg does nothing but rotate images by multiples of 90 degrees, flip, transpose,
+// and crop them, which can be done without decoding jpeg files (see LosslessJpegOpts). Flatten is ignored, as in IsUsed.
+func (cfg ProcessCfg) reorientsOnly() bool {
+	rs := cfg.Resample
+	return !cfg.Adjust.IsUsed && cfg.Transform.Angle == 0 && !cfg.Trim.IsUsed && !cfg.Convolve.IsUsed &&
+		!cfg.Composite.IsUsed && !cfg.Overlay.IsUsed && !cfg.Text.IsUsed && !cfg.Frame.IsUsed &&
+		len(cfg.Ops) == 0 && !cfg.Color.IsUsed && !rs.scales() && !(rs.Crop.Aspect > 0 && rs.Crop.Gravity == Smart)
+}
+
+// Steps returns the operations in cfg as a Pipeline: color adjustments first, then rotations and flips, then
+// trimming, then crops and resizing, then convolution filters, then layers, overlays, and text, then padding,
+// borders, rounded corners, and drop shadows, then cfg.Ops, then flattening, and finally the color mode conversion,
+// so that grayscale results of flattened images are written as single-channel images. ColorAlpha is the exception:
+// it comes before flattening, which would discard the alpha channel, and its result is opaque.
+func (cfg ProcessCfg) Steps() Pipeline {
+	steps := Pipeline{cfg.Adjust, cfg.Transform, cfg.Trim, cfg.Resample, cfg.Convolve, cfg.Composite, cfg.Overlay,
+		cfg.Text, cfg.Frame}
+	steps = append(steps, cfg.Ops...)
+	if cfg.Color.IsUsed && cfg.Color.Mode == ColorAlpha {
+		return append(steps, cfg.Color, cfg.Flatten)
+	}
+	return append(steps, cfg.Flatten, cfg.Color)
+}
+
+// Process applies the operations in cfg to img, in the order given by Steps.
+func Process(img image.Image, cfg ProcessCfg) image.Image {
+	return cfg.Steps().Apply(img)
+}
@@ -5,8 +5,6 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -16,7 +14,7 @@ type Versioner struct {
 	m    sync.Mutex
 }
 
-func ProcessDir(targetDir, dstDir string, maxProcs uint, recursive bool, encCfg EncodeCfg, rsmplCfg ResampleCfg) error {
+func ProcessDir(targetDir, dstDir string, maxProcs uint, recursive bool, encCfg EncodeCfg, procCfg ProcessCfg) error {
 	tdir, err := filepath.Abs(targetDir)
 	if err != nil {
 		return err
@@ -59,39 +57,24 @@ func ProcessDir(targetDir, dstDir string, maxProcs uint, recursive bool, encCfg
 				<-workerChan
 				wg.Done()
 			}()
-			img, _, err := DecodeLocal(srcFilePath)
+			img, srcFormat, err := DecodeLocal(srcFilePath)
 			if err != nil {
 				atomic.AddUint64(&errCount, 1)
 				return
 			}
-			if rsmplCfg.IsUsed {
-				img = Rescale(img, rsmplCfg)
-			}
-			dstPath, err := GetDstFilePath("", dstDir, srcFilePath, false, encCfg.FileType)
+			dstPath, err := GetDstFilePath("", dstDir, srcFilePath, false, encCfg.Codec)
 			if err != nil {
 				atomic.AddUint64(&errCount, 1)
 				return
 			}
 
 			// check if file already exists
-			var version int
 			origDstPath := dstPath
-			for {
-				dstPath = origDstPath
+			for version := 1; ; version++ {
 				// start by checking for conflicts with existing files in the dst directory
 				if _, err = os.Stat(dstPath); err == nil {
-					fdir := filepath.Dir(dstPath)
-					fNameExt := filepath.Base(dstPath)
-					fNameExtSlice := strings.Split(fNameExt, ".")
-					if len(fNameExt) < 1 {
-						atomic.AddUint64(&errCount, 1)
-						return
-					}
-					for ; err == nil; version++ {
-						dstNameVersionExt := fNameExtSlice[0] + "_v" + strconv.Itoa(version+1) + "." + fNameExtSlice[1]
-						dstPath = filepath.Join(fdir, dstNameVersionExt)
-						_, err = os.Stat(dstPath)
-					}
+					dstPath = VersionedPath(origDstPath, version)
+					continue
 				}
 				// do one final check to avoid a race with file writes in other go routines
 				v.m.Lock()
@@ -102,8 +85,9 @@ func ProcessDir(targetDir, dstDir string, maxProcs uint, recursive bool, encCfg
 					break
 				}
 				v.m.Unlock()
+				dstPath = VersionedPath(origDstPath, version)
 			}
-			err = SaveFile(img, dstPath, encCfg)
+			err = SaveImage(img, srcFilePath, srcFormat, dstPath, encCfg, procCfg)
 			if err != nil {
 				atomic.AddUint64(&errCount, 1)
 			}
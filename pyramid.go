@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Halve reduces src to half its width and height by averaging each 2x2 block of pixels.
+// If a dimension is odd, the last row or column is dropped.
+func Halve(src *image.RGBA64) *image.RGBA64 {
+	sb := src.Bounds()
+	dst := image.NewRGBA64(image.Rect(0, 0, sb.Dx()/2, sb.Dy()/2))
+	for y := 0; y < dst.Rect.Max.Y; y++ {
+		r0 := src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+2*y):]
+		r1 := src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+2*y+1):]
+		d := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dst.Rect.Max.X; x++ {
+			// 4 channels, 2 bytes each
+			for c := 0; c < 8; c += 2 {
+				i := x*16 + c
+				sum := uint32(r0[i])<<8 | uint32(r0[i+1])
+				sum += uint32(r0[i+8])<<8 | uint32(r0[i+9])
+				sum += uint32(r1[i])<<8 | uint32(r1[i+1])
+				sum += uint32(r1[i+8])<<8 | uint32(r1[i+9])
+				sum = (sum + 2) / 4
+				d[x*8+c], d[x*8+c+1] = uint8(sum>>8), uint8(sum)
+			}
+		}
+	}
+	return dst
+}
+
+// PyramidReduce repeatedly halves src while the result is still at least as large as dw x dh,
+// leaving less than a 2x reduction for the final resampling pass.
+func PyramidReduce(src image.Image, dw, dh int) image.Image {
+	sb := src.Bounds()
+	if sb.Dx()/2 < dw || sb.Dy()/2 < dh {
+		return src
+	}
+	work, ok := src.(*image.RGBA64)
+	if !ok {
+		// RGBA64 is premultiplied, so averaging does not bleed the color of transparent pixels
+		work = image.NewRGBA64(sb)
+		draw.Draw(work, sb, src, sb.Min, draw.Src)
+	}
+	for work.Rect.Dx()/2 >= dw && work.Rect.Dy()/2 >= dh {
+		work = Halve(work)
+	}
+	return work
+}
+
+// usePyramid reports whether resampling srcRect to dstRect should start with PyramidReduce
+func usePyramid(srcRect, dstRect image.Rectangle, cfg ResampleCfg) bool {
+	if cfg.PyramidRatio <= 0 || dstRect.Dx() < 1 || dstRect.Dy() < 1 {
+		return false
+	}
+	// nearest neighbor is meant to be fast, and area averaging is already exact
+	if cfg.Interpolator == xdraw.NearestNeighbor || cfg.Interpolator == Area {
+		return false
+	}
+	ratioX := float64(srcRect.Dx()) / float64(dstRect.Dx())
+	ratioY := float64(srcRect.Dy()) / float64(dstRect.Dy())
+	return min(ratioX, ratioY) >= cfg.PyramidRatio
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// MedianCut is a draw.Quantizer that builds a palette by repeatedly splitting the box of colors with the most
+// pixels times its widest channel range at the median of that channel. It can also be used as EncodeCfg.GifQuantizer.
+type MedianCut struct{}
+
+// the colors are reduced to 5 bits per channel before they are counted
+const quantBits = 5
+
+type colorCount struct {
+	c [4]uint8 // premultiplied RGBA, quantBits per channel
+	n int
+}
+
+type colorBox []colorCount
+
+func (b colorBox) pixels() int {
+	n := 0
+	for _, c := range b {
+		n += c.n
+	}
+	return n
+}
+
+// widest returns the channel with the largest range in b, and the range
+func (b colorBox) widest() (int, int) {
+	lo, hi := [4]uint8{255, 255, 255, 255}, [4]uint8{}
+	for _, c := range b {
+		for i := range lo {
+			lo[i], hi[i] = min(lo[i], c.c[i]), max(hi[i], c.c[i])
+		}
+	}
+	ch, r := 0, -1
+	for i := range lo {
+		if int(hi[i])-int(lo[i]) > r {
+			ch, r = i, int(hi[i])-int(lo[i])
+		}
+	}
+	return ch, r
+}
+
+// average returns the color of b, weighted by the number of pixels of each color
+func (b colorBox) average() color.Color {
+	var sum [4]int
+	n := b.pixels()
+	for _, c := range b {
+		for i := range sum {
+			sum[i] += int(c.c[i]) * c.n
+		}
+	}
+	scale := func(v int) uint8 {
+		return uint8((v*255/((1<<quantBits)-1) + n/2) / n)
+	}
+	return color.RGBA{scale(sum[0]), scale(sum[1]), scale(sum[2]), scale(sum[3])}
+}
+
+// Quantize appends up to cap(p)-len(p) colors to p, as required by draw.Quantizer.
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+	counts := map[[4]uint8]int{}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := m.At(x, y).RGBA()
+			const shift = 16 - quantBits
+			counts[[4]uint8{uint8(r >> shift), uint8(g >> shift), uint8(bl >> shift), uint8(a >> shift)}]++
+		}
+	}
+	all := make(colorBox, 0, len(counts))
+	for c, k := range counts {
+		all = append(all, colorCount{c, k})
+	}
+	if len(all) == 0 {
+		return p
+	}
+	boxes := []colorBox{all}
+	for len(boxes) < n {
+		// split the box with the most pixels times its widest range
+		best, bestScore, bestCh := -1, 0, 0
+		for i, box := range boxes {
+			ch, r := box.widest()
+			if score := box.pixels() * r; r > 0 && score > bestScore {
+				best, bestScore, bestCh = i, score, ch
+			}
+		}
+		if best < 0 {
+			break // every box holds a single color
+		}
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i].c[bestCh] < box[j].c[bestCh] })
+		// cut at the pixel median, keeping at least one color on each side
+		half, acc, cut := box.pixels()/2, 0, 1
+		for i, c := range box[:len(box)-1] {
+			acc += c.n
+			cut = i + 1
+			if acc >= half {
+				break
+			}
+		}
+		boxes[best] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+	for _, box := range boxes {
+		p = append(p, box.average())
+	}
+	return p
+}
+
+type QuantizeCfg struct {
+	IsUsed bool
+	Colors int  // 2-256
+	Dither bool // diffuse the quantization error with Floyd-Steinberg dithering
+}
+
+func NewQuantizeCfg(opts ...QuantizeOpt) QuantizeCfg {
+	cfg := QuantizeCfg{
+		IsUsed: false,
+		Colors: 256,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type QuantizeOpt func(*QuantizeCfg)
+
+// n is clamped to 2-256
+func WithQuantize(n int) func(*QuantizeCfg) {
+	return func(q *QuantizeCfg) {
+		q.IsUsed = true
+		q.Colors = min(max(n, 2), 256)
+	}
+}
+
+func WithDither(dither bool) func(*QuantizeCfg) {
+	return func(q *QuantizeCfg) {
+		q.Dither = dither
+	}
+}
+
+// Quantize returns img reduced to at most cfg.Colors colors with MedianCut, as an *image.Paletted with its
+// origin at (0, 0).
+func Quantize(img image.Image, cfg QuantizeCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	b := img.Bounds()
+	pal := MedianCut{}.Quantize(make(color.Palette, 0, cfg.Colors), img)
+	dst := image.NewPaletted(b.Sub(b.Min), pal)
+	var drawer draw.Drawer = draw.Src
+	if cfg.Dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(dst, dst.Bounds(), img, b.Min)
+	return dst
+}
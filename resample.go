@@ -2,6 +2,7 @@ package main
 
 import (
 	"image"
+	"image/color"
 
 	"golang.org/x/image/draw"
 )
@@ -16,6 +17,14 @@ type ResampleCfg struct {
 	Height        int
 	Interpolator  draw.Interpolator
 	AllowUpsize   bool
+	HighBitDepth  bool
+	LinearLight   bool
+	PyramidRatio  float64
+	Threads       int
+	Crop          CropCfg
+	Fit           FitCfg
+	SeamCarve     bool
+	SeamMask      image.Image
 }
 
 func NewResampleCfg(opts ...ResampleOpt) ResampleCfg {
@@ -29,6 +38,10 @@ func NewResampleCfg(opts ...ResampleOpt) ResampleCfg {
 		Height:        -1,
 		Interpolator:  draw.CatmullRom,
 		AllowUpsize:   false,
+		HighBitDepth:  true,
+		LinearLight:   false,
+		PyramidRatio:  4,
+		Threads:       0,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -44,6 +57,86 @@ func WithAllowUpsize(allowUpsize bool) func(*ResampleCfg) {
 	}
 }
 
+// if true, 16-bit sources are resampled to 16-bit images; this should only be set if the output format supports them
+func WithHighBitDepth(highBitDepth bool) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.HighBitDepth = highBitDepth
+	}
+}
+
+// if true, images are converted to linear light before resampling and back to sRGB afterward;
+// this avoids darkening fine detail and high-contrast edges when downscaling, at some cost in speed
+func WithLinearLight(linear bool) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.LinearLight = linear
+	}
+}
+
+// when an image is reduced by at least this ratio, it is first halved repeatedly with a fast box filter,
+// and only the final reduction is done by the interpolator; values <= 0 disable this
+func WithPyramidRatio(ratio float64) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.PyramidRatio = ratio
+	}
+}
+
+// the number of goroutines used to resample a single image; values < 1 use all CPUs
+func WithThreads(n int) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.Threads = n
+	}
+}
+
+// crops the image to rect (x, y, width, height), in pixels or, if percent is true, in percent of the image dimensions
+func WithCropRect(rect [4]float64, percent bool) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.IsUsed = true
+		r.Crop.IsUsed = true
+		r.Crop.Rect = rect
+		r.Crop.Percent = percent
+	}
+}
+
+// crops the image to the largest region with the given aspect ratio (width / height), positioned according to gravity
+func WithCropAspect(aspect float64, gravity Gravity) func(*ResampleCfg) {
+	if aspect <= 0 {
+		return func(*ResampleCfg) {}
+	}
+	return func(r *ResampleCfg) {
+		r.IsUsed = true
+		r.Crop.IsUsed = true
+		r.Crop.Aspect = aspect
+		r.Crop.Gravity = gravity
+	}
+}
+
+// if true, crops are applied to the scaled image instead of the source image
+func WithCropAfterScale(after bool) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.Crop.AfterScale = after
+	}
+}
+
+// sizes the image to a width x height box according to mode; gravity and pad are used by cover and contain
+func WithFit(mode FitMode, width, height int, gravity Gravity, pad color.Color) func(*ResampleCfg) {
+	if mode == FitNone || width < 1 || height < 1 {
+		return func(*ResampleCfg) {}
+	}
+	return func(r *ResampleCfg) {
+		r.IsUsed = true
+		r.Fit = FitCfg{Mode: mode, Width: width, Height: height, Gravity: gravity, Pad: pad}
+	}
+}
+
+// if true, images are resized by seam carving rather than by the interpolator; bright areas of mask,
+// which may be nil, are protected from removal
+func WithSeamCarve(seamCarve bool, mask image.Image) func(*ResampleCfg) {
+	return func(r *ResampleCfg) {
+		r.SeamCarve = seamCarve
+		r.SeamMask = mask
+	}
+}
+
 func WithRescale(height, width, scaleToHeight, scaleToWidth, maxSidePixels, minSidePixels int) func(*ResampleCfg) {
 	if height > 0 || width > 0 {
 		return func(r *ResampleCfg) {
@@ -79,27 +172,45 @@ func WithRescale(height, width, scaleToHeight, scaleToWidth, maxSidePixels, minS
 	return func(*ResampleCfg) {}
 }
 
+// unrecognized names are ignored; use ParseInterpolator to validate them first
 func WithInterpolator(s string) func(r *ResampleCfg) {
-	switch s {
-	case "CatmullRom":
-		return func(r *ResampleCfg) {
-			r.Interpolator = draw.CatmullRom
-		}
-	case "NearestNeighbor":
-		return func(r *ResampleCfg) {
-			r.Interpolator = draw.NearestNeighbor
-		}
-	case "ApproxBiLinear":
-		return func(r *ResampleCfg) {
-			r.Interpolator = draw.ApproxBiLinear
-		}
-	default:
+	interp, err := ParseInterpolator(s)
+	if err != nil {
 		return func(r *ResampleCfg) {}
 	}
+	return func(r *ResampleCfg) {
+		r.Interpolator = interp
+	}
+}
+
+// scales reports whether cfg resizes images, as opposed to only cropping them
+func (cfg ResampleCfg) scales() bool {
+	return cfg.Fit.Mode != FitNone || cfg.Width > 0 || cfg.Height > 0 || cfg.ScaleToWidth > 0 ||
+		cfg.ScaleToHeight > 0 || cfg.MaxSidePxls > 0 || cfg.MinSidePxls > 0
 }
 
+// DstRect returns the bounds of the image produced by Rescale, including any crop.
 func DstRect(srcRect image.Rectangle, cfg ResampleCfg) image.Rectangle {
-	dstRect := srcRect //srcRect.Bounds()
+	if !cfg.Crop.AfterScale {
+		srcRect = CropRect(srcRect, cfg.Crop)
+	}
+	dstRect := scaledRect(srcRect.Sub(srcRect.Min), cfg)
+	dstRect = fitBox(dstRect, cfg.Fit)
+	if cfg.Crop.AfterScale {
+		dstRect = CropRect(dstRect, cfg.Crop)
+	}
+	return dstRect.Sub(dstRect.Min)
+}
+
+// scaledRect returns the size of srcRect after scaling; srcRect.Min must be (0, 0)
+func scaledRect(srcRect image.Rectangle, cfg ResampleCfg) image.Rectangle {
+	if srcRect.Empty() {
+		return srcRect // there is nothing to scale, and the proportions are undefined
+	}
+	if cfg.Fit.Mode != FitNone {
+		return fitScaledRect(srcRect.Size(), cfg.Fit)
+	}
+	dstRect := srcRect
 	var done bool
 	if cfg.Height > 0 {
 		dstRect.Max.Y = cfg.Height
@@ -174,8 +285,40 @@ func DstRect(srcRect image.Rectangle, cfg ResampleCfg) image.Rectangle {
 }
 
 func Rescale(src image.Image, cfg ResampleCfg) image.Image {
-	dstRect := DstRect(src.Bounds(), cfg)
-	dstImg := image.NewNRGBA(dstRect)
-	cfg.Interpolator.Scale(dstImg, dstImg.Bounds(), src, src.Bounds(), draw.Over, nil)
+	if !cfg.Crop.AfterScale {
+		src = Crop(src, cfg.Crop)
+	}
+	srcRect := src.Bounds()
+	dstRect := scaledRect(srcRect.Sub(srcRect.Min), cfg)
+	if dstRect.Size() != srcRect.Size() {
+		src = scale(src, dstRect, cfg)
+	}
+	src = applyFit(src, cfg.Fit, cfg.HighBitDepth)
+	if cfg.Crop.AfterScale {
+		src = Crop(src, cfg.Crop)
+	}
+	return src
+}
+
+func scale(src image.Image, dstRect image.Rectangle, cfg ResampleCfg) image.Image {
+	if cfg.SeamCarve {
+		return seamCarve(src, dstRect, cfg)
+	}
+	dstImg := NewDstImage(src, dstRect, cfg.HighBitDepth)
+
+	work := src
+	if cfg.LinearLight {
+		work = ToLinear(src)
+	}
+	if usePyramid(src.Bounds(), dstRect, cfg) {
+		work = PyramidReduce(work, dstRect.Dx(), dstRect.Dy())
+	}
+	if cfg.LinearLight {
+		lin := image.NewRGBA64(dstRect)
+		ParallelScale(cfg.Interpolator, lin, dstRect, work, work.Bounds(), draw.Src, cfg.Threads)
+		FromLinear(dstImg, lin)
+		return dstImg
+	}
+	ParallelScale(cfg.Interpolator, dstImg, dstImg.Bounds(), work, work.Bounds(), draw.Over, cfg.Threads)
 	return dstImg
 }
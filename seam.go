@@ -0,0 +1,241 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// mask values are multiplied by this before being added to a pixel's energy,
+// so that protected pixels are only removed once everything else is gone
+const seamProtectEnergy = 1e6
+
+// carver holds the working state of a seam carving operation. Pixels are stored row by row
+// with a fixed stride; w shrinks or grows as seams are removed or inserted.
+type carver struct {
+	w, h, stride int
+	pix          []color.NRGBA64
+	protect      []float64 // per pixel energy bonus from the protection mask
+	orig         []int     // the original column of each pixel; only used to find seams to insert
+}
+
+func newCarver(img image.Image, mask image.Image) *carver {
+	b := img.Bounds()
+	c := &carver{w: b.Dx(), h: b.Dy(), stride: b.Dx()}
+	c.pix = make([]color.NRGBA64, c.w*c.h)
+	c.protect = make([]float64, c.w*c.h)
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			c.pix[y*c.stride+x] = color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+		}
+	}
+	if mask != nil {
+		// the mask is stretched to the size of the image
+		mb := mask.Bounds()
+		for y := 0; y < c.h; y++ {
+			for x := 0; x < c.w; x++ {
+				mx := mb.Min.X + x*mb.Dx()/c.w
+				my := mb.Min.Y + y*mb.Dy()/c.h
+				g := color.Gray16Model.Convert(mask.At(mx, my)).(color.Gray16)
+				c.protect[y*c.stride+x] = seamProtectEnergy * float64(g.Y) / 0xffff
+			}
+		}
+	}
+	return c
+}
+
+// transpose swaps the rows and columns of c, so that horizontal seams can be handled as vertical ones
+func (c *carver) transpose() *carver {
+	t := &carver{w: c.h, h: c.w, stride: c.h}
+	t.pix = make([]color.NRGBA64, t.w*t.h)
+	t.protect = make([]float64, t.w*t.h)
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			t.pix[x*t.stride+y] = c.pix[y*c.stride+x]
+			t.protect[x*t.stride+y] = c.protect[y*c.stride+x]
+		}
+	}
+	return t
+}
+
+func (c *carver) image() *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, c.w, c.h))
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			img.SetNRGBA64(x, y, c.pix[y*c.stride+x])
+		}
+	}
+	return img
+}
+
+func seamLum(p color.NRGBA64) float64 {
+	// premultiply, so that differences between transparent pixels do not count
+	a := float64(p.A) / 0xffff
+	return a * (0.2126*float64(p.R) + 0.7152*float64(p.G) + 0.0722*float64(p.B)) / 0xffff
+}
+
+// energy returns the gradient magnitude of every pixel plus its protection bonus
+func (c *carver) energy() []float64 {
+	lum := make([]float64, c.w*c.h)
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			lum[y*c.w+x] = seamLum(c.pix[y*c.stride+x])
+		}
+	}
+	e := make([]float64, c.w*c.h)
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			dx := lum[y*c.w+min(x+1, c.w-1)] - lum[y*c.w+max(x-1, 0)]
+			dy := lum[min(y+1, c.h-1)*c.w+x] - lum[max(y-1, 0)*c.w+x]
+			e[y*c.w+x] = math.Abs(dx) + math.Abs(dy) + c.protect[y*c.stride+x]
+		}
+	}
+	return e
+}
+
+// findSeam returns the column of the lowest energy vertical seam in each row
+func (c *carver) findSeam() []int {
+	e := c.energy()
+	// accumulate the minimum energy of any seam ending at each pixel
+	for y := 1; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			best := e[(y-1)*c.w+x]
+			if x > 0 {
+				best = math.Min(best, e[(y-1)*c.w+x-1])
+			}
+			if x < c.w-1 {
+				best = math.Min(best, e[(y-1)*c.w+x+1])
+			}
+			e[y*c.w+x] += best
+		}
+	}
+	seam := make([]int, c.h)
+	last := (c.h - 1) * c.w
+	for x := 1; x < c.w; x++ {
+		if e[last+x] < e[last+seam[c.h-1]] {
+			seam[c.h-1] = x
+		}
+	}
+	for y := c.h - 2; y >= 0; y-- {
+		prev := seam[y+1]
+		seam[y] = prev
+		for x := max(prev-1, 0); x <= min(prev+1, c.w-1); x++ {
+			if e[y*c.w+x] < e[y*c.w+seam[y]] {
+				seam[y] = x
+			}
+		}
+	}
+	return seam
+}
+
+func (c *carver) removeSeam(seam []int) {
+	for y, x := range seam {
+		row := y * c.stride
+		copy(c.pix[row+x:row+c.w-1], c.pix[row+x+1:row+c.w])
+		copy(c.protect[row+x:row+c.w-1], c.protect[row+x+1:row+c.w])
+		if c.orig != nil {
+			copy(c.orig[row+x:row+c.w-1], c.orig[row+x+1:row+c.w])
+		}
+	}
+	c.w--
+}
+
+// narrow removes n vertical seams
+func (c *carver) narrow(n int) {
+	for i := 0; i < n && c.w > 1; i++ {
+		c.removeSeam(c.findSeam())
+	}
+}
+
+// widen inserts n vertical seams. The seams are found by removing n seams from a copy, so that the
+// same low energy seam is not duplicated over and over; each inserted pixel averages its neighbors.
+func (c *carver) widen(n int) {
+	for n > 0 {
+		// insert at most half as many seams as there are columns at a time, to avoid stretching
+		batch := min(n, max(c.w/2, 1))
+		n -= batch
+
+		cp := &carver{w: c.w, h: c.h, stride: c.stride}
+		cp.pix = append([]color.NRGBA64(nil), c.pix...)
+		cp.protect = append([]float64(nil), c.protect...)
+		cp.orig = make([]int, len(c.pix))
+		for y := 0; y < c.h; y++ {
+			for x := 0; x < c.w; x++ {
+				cp.orig[y*c.stride+x] = x
+			}
+		}
+		dup := make([][]bool, c.h)
+		for y := range dup {
+			dup[y] = make([]bool, c.w)
+		}
+		if c.w == 1 {
+			// no seams can be removed from a single column, which is its own only seam
+			for y := range dup {
+				dup[y][0] = true
+			}
+		}
+		for i := 0; i < batch && cp.w > 1; i++ {
+			seam := cp.findSeam()
+			for y, x := range seam {
+				dup[y][cp.orig[y*cp.stride+x]] = true
+			}
+			cp.removeSeam(seam)
+		}
+
+		stride := c.w + batch
+		pix := make([]color.NRGBA64, stride*c.h)
+		protect := make([]float64, stride*c.h)
+		w := 0
+		for y := 0; y < c.h; y++ {
+			nx := 0
+			for x := 0; x < c.w; x++ {
+				p := c.pix[y*c.stride+x]
+				pix[y*stride+nx], protect[y*stride+nx] = p, c.protect[y*c.stride+x]
+				nx++
+				if dup[y][x] {
+					q := c.pix[y*c.stride+min(x+1, c.w-1)]
+					pix[y*stride+nx] = color.NRGBA64{
+						uint16((uint32(p.R) + uint32(q.R)) / 2),
+						uint16((uint32(p.G) + uint32(q.G)) / 2),
+						uint16((uint32(p.B) + uint32(q.B)) / 2),
+						uint16((uint32(p.A) + uint32(q.A)) / 2)}
+					protect[y*stride+nx] = c.protect[y*c.stride+x]
+					nx++
+				}
+			}
+			w = nx
+		}
+		c.pix, c.protect, c.stride, c.w = pix, protect, stride, w
+	}
+}
+
+func (c *carver) resizeWidth(w int) {
+	if w < c.w {
+		c.narrow(c.w - w)
+	} else if w > c.w {
+		c.widen(w - c.w)
+	}
+}
+
+// SeamCarve resizes img to w x h by removing or inserting seams of low energy pixels, which changes
+// the aspect ratio without cropping or stretching the important parts of the image. Bright areas of
+// the optional mask (which is stretched to the size of img) are protected from removal.
+func SeamCarve(img image.Image, w, h int, mask image.Image) image.Image {
+	c := newCarver(img, mask)
+	c.resizeWidth(w)
+	if h != c.h {
+		c = c.transpose()
+		c.resizeWidth(h)
+		c = c.transpose()
+	}
+	return c.image()
+}
+
+// seamCarve resizes src to dstRect according to cfg and converts the result to the usual output type
+func seamCarve(src image.Image, dstRect image.Rectangle, cfg ResampleCfg) image.Image {
+	carved := SeamCarve(src, dstRect.Dx(), dstRect.Dy(), cfg.SeamMask)
+	dst := NewDstImage(src, dstRect, cfg.HighBitDepth)
+	draw.Draw(dst, dstRect, carved, image.Point{}, draw.Src)
+	return dst
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// contrib is the weight of one source row or column in a destination row or column
+type contrib struct {
+	i int
+	w float32
+}
+
+// weights holds the normalized contributions to each destination row or column
+type weights [][]contrib
+
+// areaWeights distributes sn source pixels over dn destination pixels in proportion
+// to how much of each source pixel is covered by each destination pixel
+func areaWeights(dn, sn int) weights {
+	ws := make(weights, dn)
+	scale := float64(sn) / float64(dn)
+	for x := range ws {
+		s0, s1 := float64(x)*scale, float64(x+1)*scale
+		for i := int(s0); i < sn && float64(i) < s1; i++ {
+			w := math.Min(s1, float64(i+1)) - math.Max(s0, float64(i))
+			if w > 0 {
+				ws[x] = append(ws[x], contrib{i, float32(w / scale)})
+			}
+		}
+	}
+	return ws
+}
+
+// kernelWeights distributes sn source pixels over dn destination pixels the same way draw.Kernel.Scale does;
+// when shrinking, the kernel is stretched so that every source pixel is visited
+func kernelWeights(q *draw.Kernel, dn, sn int) weights {
+	ws := make(weights, dn)
+	scale := float64(sn) / float64(dn)
+	halfWidth, argScale := q.Support, 1.0
+	if scale > 1 {
+		halfWidth *= scale
+		argScale = 1 / scale
+	}
+	for x := range ws {
+		center := (float64(x)+0.5)*scale - 0.5
+		i0 := max(int(math.Floor(center-halfWidth)), 0)
+		i1 := min(int(math.Ceil(center+halfWidth)), sn)
+		var total float64
+		for i := i0; i < i1; i++ {
+			t := math.Abs((center - float64(i)) * argScale)
+			if t >= q.Support {
+				continue
+			}
+			w := q.At(t)
+			if w == 0 {
+				continue
+			}
+			total += w
+			ws[x] = append(ws[x], contrib{i, float32(w)})
+		}
+		for k := range ws[x] {
+			ws[x][k].w /= float32(total)
+		}
+	}
+	return ws
+}
+
+// readPremulRow copies row y of src, from x0 to x1, into buf as premultiplied RGBA values in [0, 1]
+func readPremulRow(buf []float32, src image.Image, x0, x1, y int) {
+	rgba64, _ := src.(image.RGBA64Image)
+	for x := x0; x < x1; x++ {
+		var c color.RGBA64
+		if rgba64 != nil {
+			c = rgba64.RGBA64At(x, y)
+		} else {
+			r, g, b, a := src.At(x, y).RGBA()
+			c = color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+		}
+		p := buf[(x-x0)*4 : (x-x0)*4+4]
+		p[0], p[1], p[2], p[3] = float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff, float32(c.A)/0xffff
+	}
+}
+
+func clamp01(f float32) float32 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// writePremul stores a premultiplied color in dst, compositing it over the existing pixel if op is draw.Over
+func writePremul(dst draw.Image, x, y int, p []float32, op draw.Op) {
+	r, g, b, a := clamp01(p[0]), clamp01(p[1]), clamp01(p[2]), clamp01(p[3])
+	// premultiplied color channels cannot exceed alpha
+	r, g, b = min(r, a), min(g, a), min(b, a)
+	if op == draw.Over && a < 1 {
+		dr, dg, db, da := dst.At(x, y).RGBA()
+		inv := (1 - a) / 0xffff
+		r += float32(dr) * inv
+		g += float32(dg) * inv
+		b += float32(db) * inv
+		a += float32(da) * inv
+	}
+	c := color.RGBA64{uint16(r*0xffff + 0.5), uint16(g*0xffff + 0.5), uint16(b*0xffff + 0.5), uint16(a*0xffff + 0.5)}
+	if d, ok := dst.(draw.RGBA64Image); ok {
+		d.SetRGBA64(x, y, c)
+		return
+	}
+	dst.Set(x, y, c)
+}
+
+// Threads returns n, or the number of usable CPUs if n < 1
+func Threads(n int) int {
+	if n < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// forBands splits r into horizontal bands and calls fn for each of them on up to threads goroutines
+func forBands(r image.Rectangle, threads int, fn func(band image.Rectangle)) {
+	threads = Threads(threads)
+	if threads == 1 || r.Dy() < 2 {
+		fn(r)
+		return
+	}
+	// use more bands than threads so that uneven bands do not leave threads idle
+	bandHeight := max((r.Dy()+threads*4-1)/(threads*4), 8)
+	bands := make(chan image.Rectangle)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for band := range bands {
+				fn(band)
+			}
+		}()
+	}
+	for y := r.Min.Y; y < r.Max.Y; y += bandHeight {
+		bands <- image.Rect(r.Min.X, y, r.Max.X, min(y+bandHeight, r.Max.Y))
+	}
+	close(bands)
+	wg.Wait()
+}
+
+// separableScale scales the sr region of src to the dr region of dst in two passes, first distributing
+// columns according to wx, then rows according to wy. The destination is processed in horizontal bands,
+// each of which only runs the first pass over the source rows that its kernel support reaches.
+func separableScale(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, wx, wy weights, threads int) {
+	adr := dst.Bounds().Intersect(dr)
+	if adr.Empty() || sr.Empty() {
+		return
+	}
+	sw, dw := sr.Dx(), dr.Dx()
+	forBands(adr, threads, func(band image.Rectangle) {
+		// the range of source rows (relative to sr) that contribute to this band
+		r0, r1 := sr.Dy(), 0
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			for _, c := range wy[y-dr.Min.Y] {
+				r0, r1 = min(r0, c.i), max(r1, c.i+1)
+			}
+		}
+		if r0 >= r1 {
+			return
+		}
+
+		// horizontal pass: r1-r0 rows of dw pixels
+		row := make([]float32, sw*4)
+		tmp := make([]float32, dw*(r1-r0)*4)
+		for y := r0; y < r1; y++ {
+			readPremulRow(row, src, sr.Min.X, sr.Max.X, sr.Min.Y+y)
+			out := tmp[(y-r0)*dw*4 : (y-r0+1)*dw*4]
+			for x, cs := range wx {
+				var r, g, b, a float32
+				for _, c := range cs {
+					p := row[c.i*4 : c.i*4+4]
+					r += p[0] * c.w
+					g += p[1] * c.w
+					b += p[2] * c.w
+					a += p[3] * c.w
+				}
+				out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, b, a
+			}
+		}
+
+		// vertical pass, only for the affected columns
+		var p [4]float32
+		for y := band.Min.Y; y < band.Max.Y; y++ {
+			cs := wy[y-dr.Min.Y]
+			for x := band.Min.X; x < band.Max.X; x++ {
+				p = [4]float32{}
+				col := (x - dr.Min.X) * 4
+				for _, c := range cs {
+					off := (c.i-r0)*dw*4 + col
+					q := tmp[off : off+4]
+					p[0] += q[0] * c.w
+					p[1] += q[1] * c.w
+					p[2] += q[2] * c.w
+					p[3] += q[3] * c.w
+				}
+				writePremul(dst, x, y, p[:], op)
+			}
+		}
+	})
+}
+
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// ParallelScale is equivalent to interp.Scale, but the destination is split into horizontal bands
+// that are resampled concurrently on up to threads goroutines (all CPUs if threads < 1).
+func ParallelScale(interp draw.Interpolator, dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, threads int) {
+	switch q := interp.(type) {
+	case *draw.Kernel:
+		separableScale(dst, dr, src, sr, op, kernelWeights(q, dr.Dx(), sr.Dx()), kernelWeights(q, dr.Dy(), sr.Dy()), threads)
+	case areaInterpolator:
+		separableScale(dst, dr, src, sr, op, areaWeights(dr.Dx(), sr.Dx()), areaWeights(dr.Dy(), sr.Dy()), threads)
+	default:
+		// the remaining interpolators compute each destination pixel independently,
+		// so each band can simply be scaled into a sub-image of dst
+		si, ok := dst.(subImager)
+		if !ok {
+			interp.Scale(dst, dr, src, sr, op, nil)
+			return
+		}
+		forBands(dst.Bounds().Intersect(dr), threads, func(band image.Rectangle) {
+			interp.Scale(si.SubImage(band).(draw.Image), dr, src, sr, op, nil)
+		})
+	}
+}
+
+type areaInterpolator struct{}
+
+func (areaInterpolator) Scale(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, opts *draw.Options) {
+	separableScale(dst, dr, src, sr, op, areaWeights(dr.Dx(), sr.Dx()), areaWeights(dr.Dy(), sr.Dy()), 1)
+}
+
+func (areaInterpolator) Transform(dst draw.Image, s2d f64.Aff3, src image.Image, sr image.Rectangle, op draw.Op, opts *draw.Options) {
+	// area averaging is only defined for axis-aligned scaling
+	draw.BiLinear.Transform(dst, s2d, src, sr, op, opts)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// the relative importance of each feature when scoring crop windows
+const (
+	smartEdgeWeight       = 1.0
+	smartSkinWeight       = 1.8
+	smartSaturationWeight = 0.3
+	// a slight preference for centered windows, so that featureless images are cropped like Center
+	smartCenterWeight = 0.05
+	// the long side of the downsampled image used for analysis
+	smartAnalysisSize = 256
+)
+
+// SmartCrop returns the w x h window inside r, a region of img, that contains the most interesting content.
+// Windows are scored by edge energy, skin tones, and saturation, so that the subjects of photos are kept.
+func SmartCrop(img image.Image, r image.Rectangle, w, h int) image.Rectangle {
+	w, h = min(w, r.Dx()), min(h, r.Dy())
+	if w == r.Dx() && h == r.Dy() || w < 1 || h < 1 {
+		return Center.Place(r, w, h)
+	}
+
+	// analyze a small copy of the region; s is the size of an analysis pixel in source pixels
+	s := math.Max(1, float64(max(r.Dx(), r.Dy()))/smartAnalysisSize)
+	aw, ah := max(int(float64(r.Dx())/s), 1), max(int(float64(r.Dy())/s), 1)
+	small := image.NewNRGBA(image.Rect(0, 0, aw, ah))
+	ParallelScale(Area, small, small.Bounds(), img, r, draw.Src, 0)
+	sat := summedArea(importance(small), aw, ah)
+
+	// the window size in analysis pixels
+	ww := min(max(int(math.Round(float64(w)/s)), 1), aw)
+	wh := min(max(int(math.Round(float64(h)/s)), 1), ah)
+	total := sat[len(sat)-1] + 1e-9
+
+	bestX, bestY, best := 0, 0, math.Inf(-1)
+	for y := 0; y+wh <= ah; y++ {
+		for x := 0; x+ww <= aw; x++ {
+			sum := sat[(y+wh)*(aw+1)+x+ww] - sat[y*(aw+1)+x+ww] - sat[(y+wh)*(aw+1)+x] + sat[y*(aw+1)+x]
+			// distance of the window center from the image center, 0 (centered) to 1 (at an edge)
+			dx := math.Abs(float64(2*x+ww-aw)) / float64(aw)
+			dy := math.Abs(float64(2*y+wh-ah)) / float64(ah)
+			score := sum/total + smartCenterWeight*(1-math.Max(dx, dy))
+			if score > best {
+				bestX, bestY, best = x, y, score
+			}
+		}
+	}
+
+	x0 := r.Min.X + min(int(math.Round(float64(bestX)*s)), r.Dx()-w)
+	y0 := r.Min.Y + min(int(math.Round(float64(bestY)*s)), r.Dy()-h)
+	return image.Rect(x0, y0, x0+w, y0+h)
+}
+
+// importance scores every pixel of img; transparent pixels are never important
+func importance(img *image.NRGBA) []float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[y*img.Stride+x*4:]
+			lum[y*w+x] = (0.2126*float64(p[0]) + 0.7152*float64(p[1]) + 0.0722*float64(p[2])) / 255
+		}
+	}
+	at := func(x, y int) float64 {
+		return lum[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)]
+	}
+
+	scores := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[y*img.Stride+x*4:]
+			r, g, bl, a := float64(p[0])/255, float64(p[1])/255, float64(p[2])/255, float64(p[3])/255
+
+			// sobel gradient magnitude of the luminance
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			edge := math.Min(math.Hypot(gx, gy)/4, 1)
+
+			scores[y*w+x] = a * (smartEdgeWeight*edge + smartSkinWeight*skin(r, g, bl, lum[y*w+x]) + smartSaturationWeight*saturation(r, g, bl))
+		}
+	}
+	return scores
+}
+
+// skin returns how close the chromaticity of a color is to a typical skin tone, from 0 to 1
+func skin(r, g, b, lum float64) float64 {
+	if lum < 0.15 || lum > 0.95 {
+		return 0
+	}
+	mag := math.Sqrt(r*r + g*g + b*b)
+	if mag == 0 {
+		return 0
+	}
+	dr, dg, db := r/mag-0.78, g/mag-0.57, b/mag-0.44
+	d := math.Sqrt(dr*dr + dg*dg + db*db)
+	return math.Max(0, 1-d/0.25)
+}
+
+// saturation returns the HSL saturation of a color, ignoring very dark and very bright colors
+func saturation(r, g, b float64) float64 {
+	hi, lo := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l := (hi + lo) / 2
+	if hi == lo || l < 0.05 || l > 0.95 {
+		return 0
+	}
+	if l > 0.5 {
+		return (hi - lo) / (2 - hi - lo)
+	}
+	return (hi - lo) / (hi + lo)
+}
+
+// summedArea returns the summed-area table of a w x h grid of values, with an extra leading row and column of zeros
+func summedArea(v []float64, w, h int) []float64 {
+	sat := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			row += v[y*w+x]
+			sat[(y+1)*(w+1)+x+1] = sat[y*(w+1)+x+1] + row
+		}
+	}
+	return sat
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// FormatMismatch describes a source whose declared format, taken from its file extension
+// or Content-Type, does not match the format identified from its contents.
+type FormatMismatch struct {
+	Src      string
+	Declared string // the file extension or MIME type
+	Actual   *Codec
+}
+
+func (m FormatMismatch) String() string {
+	return fmt.Sprintf("%s is declared as %s but contains %s data", m.Src, m.Declared, m.Actual.Name)
+}
+
+// DetectFormat identifies the format of r from its magic bytes without consuming any input.
+// RETURNS nil IF THE FORMAT IS NOT RECOGNIZED
+func DetectFormat(r *bufio.Reader) *Codec {
+	return sniff(r)
+}
+
+// ExtFormat returns the codec implied by the extension of a file name or url path.
+// RETURNS nil IF THE EXTENSION IS MISSING OR NOT RECOGNIZED
+func ExtFormat(name string) *Codec {
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return nil
+	}
+	return LookupCodec(ext)
+}
+
+// MIMEFormat returns the codec implied by a Content-Type header value.
+// RETURNS nil IF THE MEDIA TYPE IS MISSING OR NOT RECOGNIZED
+func MIMEFormat(contentType string) *Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return nil
+	}
+	return LookupCodec(mediaType)
+}
+
+// CheckFormat compares a declared format with the detected one and logs a FormatMismatch if they differ;
+// the source is still decoded as the detected format. declared is the extension or MIME type the declared
+// codec was derived from.
+func CheckFormat(src, declared string, declaredCodec, actual *Codec) {
+	if declaredCodec == nil || actual == nil || declaredCodec == actual {
+		return
+	}
+	log.Println(FormatMismatch{Src: src, Declared: declared, Actual: actual})
+}
+
+// decodeWithHint decodes r using the codec identified by its magic bytes, falling back
+// to hint (from a file extension or Content-Type) if the contents are not recognized
+func decodeWithHint(r io.Reader, hint *Codec) (image.Image, *Codec, error) {
+	br := bufio.NewReader(r)
+	if DetectFormat(br) == nil && hint != nil && hint.Decode != nil {
+		img, err := hint.Decode(br)
+		return img, hint, err
+	}
+	return DecodeImage(br)
+}
+
+// FixExt replaces the extension of path with the output extension of c. Extensions that
+// do not belong to any registered codec are treated as part of the file name.
+func FixExt(path string, c *Codec) string {
+	ext := filepath.Ext(path)
+	if ext != "" && LookupCodec(ext) == c {
+		return path
+	}
+	if ext != "" && LookupCodec(ext) != nil {
+		path = strings.TrimSuffix(path, ext)
+	}
+	return path + "." + c.Ext()
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/gofont/gosmallcaps"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// the bundled Go fonts, by name
+var goFonts = map[string][]byte{
+	"goregular":    goregular.TTF,
+	"gobold":       gobold.TTF,
+	"goitalic":     goitalic.TTF,
+	"gobolditalic": gobolditalic.TTF,
+	"gomedium":     gomedium.TTF,
+	"gomono":       gomono.TTF,
+	"gomonobold":   gomonobold.TTF,
+	"gosmallcaps":  gosmallcaps.TTF,
+}
+
+// LoadFont returns the bundled Go font with the given name (e.g. gobold), or else parses the TrueType or
+// OpenType file at path; the first font of a collection (.ttc or .otc) is used.
+func LoadFont(path string) (*opentype.Font, error) {
+	if ttf, ok := goFonts[strings.ToLower(path)]; ok {
+		return opentype.Parse(ttf)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		names := make([]string, 0, len(goFonts))
+		for name := range goFonts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w; fonts must be a font file or one of %s", err, strings.Join(names, ", "))
+	}
+	coll, err := opentype.ParseCollection(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse font %s: %w", path, err)
+	}
+	return coll.Font(0)
+}
+
+type TextAlign int
+
+const (
+	AlignLeft TextAlign = iota
+	AlignCenter
+	AlignRight
+)
+
+func ParseTextAlign(s string) (TextAlign, error) {
+	switch strings.ToLower(s) {
+	case "left":
+		return AlignLeft, nil
+	case "center":
+		return AlignCenter, nil
+	case "right":
+		return AlignRight, nil
+	}
+	return AlignLeft, fmt.Errorf("invalid text alignment %q; options are left, center, and right", s)
+}
+
+type TextCfg struct {
+	IsUsed       bool
+	Text         string         // lines are separated by \n and wrapped to the width of Box
+	Font         *opentype.Font // nil uses Go Regular
+	Size         float64        // the font size in pixels, or as a fraction of the image height if RelativeSize is set
+	RelativeSize bool
+	Color        color.Color
+	StrokeColor  color.Color
+	StrokeWidth  float64 // pixels; 0 draws no outline
+	ShadowColor  color.Color
+	ShadowOffset image.Point
+	ShadowBlur   float64 // the standard deviation of the blur of the shadow, in pixels
+	Align        TextAlign
+	Box          [4]float64 // x, y, width, height, as in CropCfg.Rect; if empty, the image inset by half the font size
+	BoxPercent   bool
+	Gravity      Gravity // the position of the text inside Box; Smart is treated as Center
+	Threads      int
+}
+
+func NewTextCfg(text string, opts ...TextOpt) TextCfg {
+	cfg := TextCfg{
+		IsUsed:  text != "",
+		Text:    text,
+		Size:    0.05,
+		Color:   color.White,
+		Gravity: Center,
+		Align:   AlignCenter,
+
+		RelativeSize: true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type TextOpt func(*TextCfg)
+
+// nil uses Go Regular
+func WithFont(f *opentype.Font) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.Font = f
+	}
+}
+
+// size is in pixels, or a fraction of the image height if relative is true; values <= 0 are ignored
+func WithTextSize(size float64, relative bool) func(*TextCfg) {
+	if size <= 0 {
+		return func(*TextCfg) {}
+	}
+	return func(t *TextCfg) {
+		t.Size, t.RelativeSize = size, relative
+	}
+}
+
+func WithTextColor(c color.Color) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.Color = c
+	}
+}
+
+// outlines the text with c; width is in pixels
+func WithTextStroke(c color.Color, width float64) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.StrokeColor, t.StrokeWidth = c, max(width, 0)
+	}
+}
+
+// draws a shadow of the text in c, moved by offset and blurred with a Gaussian kernel of blur pixels
+func WithTextShadow(c color.Color, offset image.Point, blur float64) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.ShadowColor, t.ShadowOffset, t.ShadowBlur = c, offset, max(blur, 0)
+	}
+}
+
+func WithTextAlign(align TextAlign) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.Align = align
+	}
+}
+
+// the box that the text is wrapped to, as x, y, width, height in pixels or in percent of the image dimensions,
+// and the position of the text inside it
+func WithTextBox(box [4]float64, percent bool, gravity Gravity) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.Box, t.BoxPercent, t.Gravity = box, percent, gravity
+	}
+}
+
+// the number of goroutines used to blur the shadow; values < 1 use all CPUs
+func WithTextThreads(n int) func(*TextCfg) {
+	return func(t *TextCfg) {
+		t.Threads = n
+	}
+}
+
+// wrapText splits text into lines no wider than width, breaking at spaces; words wider than width get
+// lines of their own
+func wrapText(face font.Face, text string, width fixed.Int26_6) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, w := range words[1:] {
+			if font.MeasureString(face, line+" "+w) <= width {
+				line += " " + w
+				continue
+			}
+			lines = append(lines, line)
+			line = w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// dilate returns a copy of mask in which every pixel takes the maximum value within radius of it
+func dilate(mask *image.Alpha, radius float64) *image.Alpha {
+	r := int(math.Ceil(radius))
+	b := mask.Bounds()
+	dst := image.NewAlpha(b)
+	type offset struct{ dx, dy int }
+	var disk []offset
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if float64(dx*dx+dy*dy) <= radius*radius+radius {
+				disk = append(disk, offset{dx, dy})
+			}
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			a := mask.Pix[mask.PixOffset(x, y)]
+			if a == 0 {
+				continue
+			}
+			for _, o := range disk {
+				p := image.Pt(x+o.dx, y+o.dy)
+				if !p.In(b) {
+					continue
+				}
+				i := dst.PixOffset(p.X, p.Y)
+				dst.Pix[i] = max(dst.Pix[i], a)
+			}
+		}
+	}
+	return dst
+}
+
+// blurMask blurs mask with a Gaussian kernel of sigma pixels
+func blurMask(mask *image.Alpha, sigma float64, threads int) *image.Alpha {
+	f := separable(toFloatImage(mask, threads), gaussianKernel(sigma), threads)
+	dst := image.NewAlpha(mask.Bounds())
+	for i := range dst.Pix {
+		dst.Pix[i] = uint8(clamp01(f.pix[i*4+3])*0xff + 0.5)
+	}
+	return dst
+}
+
+// newFace returns the font face of cfg at size pixels
+func (cfg TextCfg) newFace(size float64) (font.Face, error) {
+	f := cfg.Font
+	if f == nil {
+		var err error
+		if f, err = opentype.Parse(goregular.TTF); err != nil {
+			return nil, err
+		}
+	}
+	// at 72 DPI, points are pixels
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Validate reports an error if the text in cfg cannot be drawn, e.g. because the font cannot be used or an
+// absolute size is smaller than 1 pixel. Relative sizes are at least 1 pixel on any image.
+func (cfg TextCfg) Validate() error {
+	if !cfg.IsUsed || cfg.Text == "" {
+		return nil
+	}
+	size := cfg.Size
+	if cfg.RelativeSize {
+		size = 1
+	} else if size < 1 {
+		return fmt.Errorf("invalid text size %v; the text must be at least 1 pixel high", size)
+	}
+	face, err := cfg.newFace(size)
+	if err != nil {
+		return fmt.Errorf("could not use the font: %w", err)
+	}
+	return face.Close()
+}
+
+// DrawText returns a copy of img with the text in cfg drawn over it, with its origin at (0, 0).
+// It panics if cfg is not valid (see Validate).
+func DrawText(img image.Image, cfg TextCfg) image.Image {
+	if !cfg.IsUsed || cfg.Text == "" {
+		return img
+	}
+	b := img.Bounds()
+	r := b.Sub(b.Min)
+	size := cfg.Size
+	if cfg.RelativeSize {
+		size = max(size*float64(r.Dy()), 1)
+	}
+	face, err := cfg.newFace(size)
+	if err != nil {
+		panic(err)
+	}
+	defer face.Close()
+
+	box := r.Inset(int(size / 2))
+	if cfg.Box[2] > 0 && cfg.Box[3] > 0 {
+		box = CropRect(r, CropCfg{IsUsed: true, Rect: cfg.Box, Percent: cfg.BoxPercent})
+	}
+	box = box.Inset(int(math.Ceil(cfg.StrokeWidth)))
+	lines := wrapText(face, cfg.Text, fixed.I(box.Dx()))
+	widths := make([]int, len(lines))
+	blockW := 0
+	for i, line := range lines {
+		widths[i] = font.MeasureString(face, line).Ceil()
+		blockW = max(blockW, widths[i])
+	}
+	m := face.Metrics()
+	lineH := m.Height.Ceil()
+	block := cfg.Gravity.Place(box, blockW, lineH*len(lines))
+
+	// render the text into a mask, then draw the shadow, the outline, and the fill through it
+	mask := image.NewAlpha(r)
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		x := block.Min.X
+		switch cfg.Align {
+		case AlignCenter:
+			x += (blockW - widths[i]) / 2
+		case AlignRight:
+			x += blockW - widths[i]
+		}
+		d.Dot = fixed.Point26_6{X: fixed.I(x), Y: fixed.I(block.Min.Y+i*lineH) + m.Ascent}
+		d.DrawString(line)
+	}
+
+	dst := NewDstImage(img, r, true)
+	if _, ok := dst.(*image.Gray16); ok {
+		dst = image.NewNRGBA64(r) // the text may have color
+	}
+	draw.Draw(dst, r, img, b.Min, draw.Src)
+
+	outline := mask
+	if cfg.StrokeColor != nil && cfg.StrokeWidth > 0 {
+		outline = dilate(mask, cfg.StrokeWidth)
+	}
+	if cfg.ShadowColor != nil {
+		shadow := outline
+		if cfg.ShadowBlur > 0 {
+			shadow = blurMask(outline, cfg.ShadowBlur, cfg.Threads)
+		}
+		draw.DrawMask(dst, r.Add(cfg.ShadowOffset), image.NewUniform(cfg.ShadowColor), image.Point{}, shadow, r.Min, draw.Over)
+	}
+	if outline != mask {
+		draw.DrawMask(dst, r, image.NewUniform(cfg.StrokeColor), image.Point{}, outline, r.Min, draw.Over)
+	}
+	draw.DrawMask(dst, r, image.NewUniform(cfg.Color), image.Point{}, mask, r.Min, draw.Over)
+	return dst
+}
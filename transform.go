@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Orientation is one of the eight combinations of 90 degree rotations and flips.
+// The values match the EXIF orientation tag.
+type Orientation int
+
+const (
+	OrientNormal     Orientation = iota + 1
+	OrientFlipH                  // mirror left to right
+	OrientRotate180              // rotate 180 degrees
+	OrientFlipV                  // mirror top to bottom
+	OrientTranspose              // mirror along the top-left to bottom-right diagonal
+	OrientRotate90               // rotate 90 degrees clockwise
+	OrientTransverse             // mirror along the top-right to bottom-left diagonal
+	OrientRotate270              // rotate 270 degrees clockwise
+)
+
+// how each orientation maps destination coordinates to source coordinates:
+// swap exchanges x and y, and flipX and flipY then mirror the source x and y
+var orientMaps = [...]struct{ swap, flipX, flipY bool }{
+	OrientNormal:     {false, false, false},
+	OrientFlipH:      {false, true, false},
+	OrientRotate180:  {false, true, true},
+	OrientFlipV:      {false, false, true},
+	OrientTranspose:  {true, false, false},
+	OrientRotate90:   {true, false, true},
+	OrientTransverse: {true, true, true},
+	OrientRotate270:  {true, true, false},
+}
+
+func (o Orientation) valid() bool {
+	return o >= OrientNormal && o <= OrientRotate270
+}
+
+// Swaps reports whether o exchanges the width and height of an image.
+func (o Orientation) Swaps() bool {
+	return o.valid() && orientMaps[o].swap
+}
+
+// srcPoint returns the point of a w x h source image that o moves to (x, y).
+func (o Orientation) srcPoint(x, y, w, h int) (int, int) {
+	if !o.valid() {
+		return x, y
+	}
+	m := orientMaps[o]
+	if m.swap {
+		x, y = y, x
+	}
+	if m.flipX {
+		x = w - 1 - x
+	}
+	if m.flipY {
+		y = h - 1 - y
+	}
+	return x, y
+}
+
+// Then returns the orientation equivalent to applying o and then next.
+func (o Orientation) Then(next Orientation) Orientation {
+	// find the orientation that maps the corners of a small, non-square image the same way
+	const w, h = 2, 3
+	w1, h1 := w, h
+	if o.Swaps() {
+		w1, h1 = h, w
+	}
+	w2, h2 := w1, h1
+	if next.Swaps() {
+		w2, h2 = h1, w1
+	}
+	for c := OrientNormal; c <= OrientRotate270; c++ {
+		match := true
+		for _, p := range [][2]int{{0, 0}, {w2 - 1, 0}, {0, h2 - 1}} {
+			qx, qy := next.srcPoint(p[0], p[1], w1, h1)
+			rx, ry := o.srcPoint(qx, qy, w, h)
+			cx, cy := c.srcPoint(p[0], p[1], w, h)
+			if c.Swaps() != (w2 != w) || rx != cx || ry != cy {
+				match = false
+				break
+			}
+		}
+		if match {
+			return c
+		}
+	}
+	return OrientNormal
+}
+
+// ParseFlip accepts horizontal (or h), vertical (or v), and both.
+func ParseFlip(s string) (Orientation, error) {
+	switch strings.ToLower(s) {
+	case "horizontal", "h":
+		return OrientFlipH, nil
+	case "vertical", "v":
+		return OrientFlipV, nil
+	case "both":
+		return OrientRotate180, nil
+	}
+	return OrientNormal, fmt.Errorf("invalid flip %q; options are horizontal, vertical, and both", s)
+}
+
+// Orient returns a copy of img rotated and/or flipped according to o. Pixels are copied exactly,
+// and images of the standard types keep their type; other images are converted as by NewDstImage.
+func Orient(img image.Image, o Orientation) image.Image {
+	if !o.valid() || o == OrientNormal {
+		return img
+	}
+	b := img.Bounds()
+	pix, stride, bpp, ok := pixBuffer(img)
+	if !ok {
+		conv := NewDstImage(img, b.Sub(b.Min), true)
+		draw.Draw(conv, conv.Bounds(), img, b.Min, draw.Src)
+		img, b = conv, conv.Bounds()
+		pix, stride, bpp, _ = pixBuffer(img)
+	}
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o.Swaps() {
+		dw, dh = h, w
+	}
+	dst := newPixImage(img, image.Rect(0, 0, dw, dh))
+	dpix, dstride, _, _ := pixBuffer(dst)
+	for y := 0; y < dh; y++ {
+		row := dpix[y*dstride : y*dstride+dw*bpp]
+		for x := 0; x < dw; x++ {
+			sx, sy := o.srcPoint(x, y, w, h)
+			i := sy*stride + sx*bpp
+			copy(row[x*bpp:(x+1)*bpp], pix[i:i+bpp])
+		}
+	}
+	return dst
+}
+
+// pixBuffer returns the pixels of img starting at img.Bounds().Min, with their stride and size in bytes.
+func pixBuffer(img image.Image) (pix []byte, stride, bpp int, ok bool) {
+	var off int
+	switch m := img.(type) {
+	case *image.NRGBA:
+		pix, stride, bpp, off = m.Pix, m.Stride, 4, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.RGBA:
+		pix, stride, bpp, off = m.Pix, m.Stride, 4, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.NRGBA64:
+		pix, stride, bpp, off = m.Pix, m.Stride, 8, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.RGBA64:
+		pix, stride, bpp, off = m.Pix, m.Stride, 8, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.Gray:
+		pix, stride, bpp, off = m.Pix, m.Stride, 1, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.Gray16:
+		pix, stride, bpp, off = m.Pix, m.Stride, 2, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.Alpha:
+		pix, stride, bpp, off = m.Pix, m.Stride, 1, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.Alpha16:
+		pix, stride, bpp, off = m.Pix, m.Stride, 2, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	case *image.Paletted:
+		pix, stride, bpp, off = m.Pix, m.Stride, 1, m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	default:
+		return nil, 0, 0, false
+	}
+	return pix[off:], stride, bpp, true
+}
+
+// newPixImage allocates an image of the same type as img, which must be supported by pixBuffer.
+func newPixImage(img image.Image, r image.Rectangle) image.Image {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return image.NewNRGBA(r)
+	case *image.RGBA:
+		return image.NewRGBA(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.Alpha:
+		return image.NewAlpha(r)
+	case *image.Alpha16:
+		return image.NewAlpha16(r)
+	case *image.Paletted:
+		return image.NewPaletted(r, m.Palette)
+	}
+	return nil
+}
+
+// Rotate rotates img clockwise by degrees. Multiples of 90 degrees are exact (see Orient). Other angles
+// are resampled with interp; if expand is true, the canvas grows to hold the whole rotated image, and the
+// uncovered corners are filled with bg.
+func Rotate(img image.Image, degrees float64, interp draw.Interpolator, bg color.Color, expand bool) image.Image {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	if q := degrees / 90; math.Abs(q-math.Round(q)) < 1e-9 {
+		return Orient(img, quarterTurns(int(math.Round(q))))
+	}
+
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	dw, dh := b.Dx(), b.Dy()
+	if expand {
+		// the small tolerance avoids adding a column or row because of rounding errors
+		dw = int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - 1e-6))
+		dh = int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - 1e-6))
+	}
+	dst := NewDstImage(img, image.Rect(0, 0, dw, dh), true)
+	if bg != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	// rotate about the centers of both images; y points down, so this matrix turns clockwise
+	scx, scy := float64(b.Min.X)+w/2, float64(b.Min.Y)+h/2
+	dcx, dcy := float64(dw)/2, float64(dh)/2
+	s2d := f64.Aff3{
+		cos, -sin, dcx - cos*scx + sin*scy,
+		sin, cos, dcy - sin*scx - cos*scy,
+	}
+	interp.Transform(dst, s2d, img, b, draw.Over, nil)
+	return dst
+}
+
+func quarterTurns(n int) Orientation {
+	switch (n%4 + 4) % 4 {
+	case 1:
+		return OrientRotate90
+	case 2:
+		return OrientRotate180
+	case 3:
+		return OrientRotate270
+	}
+	return OrientNormal
+}
+
+type TransformCfg struct {
+	IsUsed       bool
+	Orientation  Orientation // applied first
+	Angle        float64     // clockwise degrees, applied after Orientation; never a multiple of 90
+	Interpolator draw.Interpolator
+	Background   color.Color // fills the corners uncovered by Angle
+	Expand       bool        // grow the canvas to hold the whole image rotated by Angle
+}
+
+func NewTransformCfg(opts ...TransformOpt) TransformCfg {
+	cfg := TransformCfg{
+		IsUsed:       false,
+		Orientation:  OrientNormal,
+		Interpolator: draw.CatmullRom,
+		Background:   color.Transparent,
+		Expand:       true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+type TransformOpt func(*TransformCfg)
+
+// applies o after any orientations given by earlier options
+func WithOrientation(o Orientation) func(*TransformCfg) {
+	if !o.valid() || o == OrientNormal {
+		return func(*TransformCfg) {}
+	}
+	return func(t *TransformCfg) {
+		t.IsUsed = true
+		t.Orientation = t.Orientation.Then(o)
+	}
+}
+
+// rotates the image clockwise by degrees after any orientations given by earlier options;
+// multiples of 90 degrees are exact and are combined with them
+func WithRotate(degrees float64) func(*TransformCfg) {
+	degrees = math.Mod(degrees, 360)
+	if q := degrees / 90; math.Abs(q-math.Round(q)) < 1e-9 {
+		return WithOrientation(quarterTurns(int(math.Round(q))))
+	}
+	return func(t *TransformCfg) {
+		t.IsUsed = true
+		t.Angle = degrees
+	}
+}
+
+// the fill color of the corners uncovered by rotations that are not multiples of 90 degrees, and
+// whether the canvas grows to hold the whole image for those rotations
+func WithRotateCanvas(bg color.Color, expand bool) func(*TransformCfg) {
+	return func(t *TransformCfg) {
+		t.Background = bg
+		t.Expand = expand
+	}
+}
+
+// unrecognized names are ignored; use ParseInterpolator to validate them first
+func WithTransformInterpolator(s string) func(*TransformCfg) {
+	interp, err := ParseInterpolator(s)
+	if err != nil {
+		return func(*TransformCfg) {}
+	}
+	return func(t *TransformCfg) {
+		t.Interpolator = interp
+	}
+}
+
+// Transform applies the orientation and rotation in cfg to img.
+func Transform(img image.Image, cfg TransformCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	img = Orient(img, cfg.Orientation)
+	if cfg.Angle != 0 {
+		img = Rotate(img, cfg.Angle, cfg.Interpolator, cfg.Background, cfg.Expand)
+	}
+	return img
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// TrimMode determines which border pixels are removed by Trim.
+type TrimMode int
+
+const (
+	TrimNone TrimMode = iota
+	// TrimColor removes borders of a given color.
+	TrimColor
+	// TrimTopLeft removes borders of the color of the top-left pixel.
+	TrimTopLeft
+	// TrimAlpha removes transparent borders, whatever their color.
+	TrimAlpha
+)
+
+type TrimCfg struct {
+	IsUsed bool
+	Mode   TrimMode
+	Color  color.Color // the border color for TrimColor
+	Fuzz   float64     // 0-1; how far a pixel may be from the border color (or from transparent) and still be removed
+}
+
+// NewTrimCfg returns a TrimCfg for mode; c is the border color for TrimColor, and fuzz (0-1) is how far a pixel
+// may be from the border color (or from transparent) and still be removed.
+func NewTrimCfg(mode TrimMode, c color.Color, fuzz float64) TrimCfg {
+	return TrimCfg{IsUsed: mode != TrimNone, Mode: mode, Color: c, Fuzz: min(max(fuzz, 0), 1)}
+}
+
+// ParseTrim accepts topleft, alpha, and colors as accepted by ParseColor, e.g. white or #f8f8f8.
+func ParseTrim(s string) (TrimMode, color.Color, error) {
+	switch strings.ToLower(s) {
+	case "topleft":
+		return TrimTopLeft, nil, nil
+	case "alpha":
+		return TrimAlpha, nil, nil
+	}
+	c, err := ParseColor(s)
+	if err != nil {
+		return TrimNone, nil, err
+	}
+	return TrimColor, c, nil
+}
+
+// TrimRect returns the smallest rectangle of img that contains every pixel that does not match the border
+// selected by cfg. If every pixel matches, img.Bounds() is returned, so that the image is left unchanged.
+func TrimRect(img image.Image, cfg TrimCfg) image.Rectangle {
+	b := img.Bounds()
+	if !cfg.IsUsed || cfg.Mode == TrimNone || b.Empty() {
+		return b
+	}
+	at := func(x, y int) color.RGBA64 {
+		r, g, b, a := img.At(x, y).RGBA()
+		return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+	if m, ok := img.(image.RGBA64Image); ok {
+		at = m.RGBA64At
+	}
+
+	tol := int32(cfg.Fuzz * 0xffff)
+	var matches func(c color.RGBA64) bool
+	if cfg.Mode == TrimAlpha {
+		matches = func(c color.RGBA64) bool { return int32(c.A) <= tol }
+	} else {
+		ref := at(b.Min.X, b.Min.Y)
+		if cfg.Mode == TrimColor && cfg.Color != nil {
+			r, g, b, a := cfg.Color.RGBA()
+			ref = color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+		}
+		// compare premultiplied values, so that all fully transparent pixels match each other
+		matches = func(c color.RGBA64) bool {
+			return abs32(int32(c.R)-int32(ref.R)) <= tol && abs32(int32(c.G)-int32(ref.G)) <= tol &&
+				abs32(int32(c.B)-int32(ref.B)) <= tol && abs32(int32(c.A)-int32(ref.A)) <= tol
+		}
+	}
+	rowMatches := func(y, x0, x1 int) bool {
+		for x := x0; x < x1; x++ {
+			if !matches(at(x, y)) {
+				return false
+			}
+		}
+		return true
+	}
+	colMatches := func(x, y0, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			if !matches(at(x, y)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := b
+	for r.Min.Y < r.Max.Y && rowMatches(r.Min.Y, r.Min.X, r.Max.X) {
+		r.Min.Y++
+	}
+	if r.Min.Y == r.Max.Y {
+		return b
+	}
+	for rowMatches(r.Max.Y-1, r.Min.X, r.Max.X) {
+		r.Max.Y--
+	}
+	for colMatches(r.Min.X, r.Min.Y, r.Max.Y) {
+		r.Min.X++
+	}
+	for colMatches(r.Max.X-1, r.Min.Y, r.Max.Y) {
+		r.Max.X--
+	}
+	return r
+}
+
+func abs32(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Trim returns a copy of img without the borders selected by cfg, with its origin at (0, 0).
+func Trim(img image.Image, cfg TrimCfg) image.Image {
+	if !cfg.IsUsed {
+		return img
+	}
+	return cropTo(img, TrimRect(img, cfg))
+}